@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS world;
+
+CREATE TABLE world (
+	id	INT NOT NULL,
+	randomNumber INT NOT NULL,
+	PRIMARY KEY (id)
+);
+
+INSERT INTO
+	world(id, randomNumber)
+SELECT
+	id, floor(random() * 10000 + 1)
+FROM
+	generate_series(1, 10000) AS id;
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"math/rand"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/panjf2000/gnet/v2"
+)
+
+var (
+	db   *pgxpool.Pool
+	tmpl *template.Template
+)
+
+func openDatabase(url string) error {
+	var err error
+	db, err = pgxpool.New(context.Background(), url)
+	return err
+}
+
+type fortune struct {
+	ID      int
+	Message string
+}
+
+type world struct {
+	ID           int32 `json:"id"`
+	RandomNumber int32 `json:"randomNumber"`
+}
+
+// query appends response to w. It runs on database pool, so it may block, but must not look at the request, which is gone by then.
+type query func(dw *dbWorker, w *response)
+
+// job is a request waiting for a database worker.
+type job struct {
+	c     gnet.Conn
+	hc    *httpCodec
+	w     *response
+	query query
+}
+
+// dbWorker owns buffers reused by queries running on it.
+type dbWorker struct {
+	fortunes []fortune
+	html     bytes.Buffer
+}
+
+func (dw *dbWorker) run(jobs <-chan job) {
+	for j := range jobs {
+		j.query(dw, j.w)
+		j.hc.complete(j.c, j.w)
+	}
+}
+
+// startWorkers starts database pool. Each worker holds at most one database connection at a time, so there is no point in having more of them than connections.
+func (hs *httpServer) startWorkers(n int) {
+	hs.jobs = make(chan job, n*256)
+	for i := 0; i < n; i++ {
+		go new(dbWorker).run(hs.jobs)
+	}
+}
+
+// submit sends request to database pool. Event loop must never block, so request is rejected when pool is too far behind.
+func (hs *httpServer) submit(c gnet.Conn, hc *httpCodec, w *response, q query) {
+	select {
+	case hs.jobs <- job{c: c, hc: hc, w: w, query: q}:
+	default:
+		w.appendResponse("503 Service Unavailable", textPlain, []byte("503 Service Unavailable\n"))
+		hc.complete(c, w)
+	}
+}
+
+func dbQuery(dw *dbWorker, w *response) {
+	var wd world
+
+	err := db.QueryRow(context.Background(), "SELECT id, randomNumber FROM world WHERE id = $1", rand.Intn(10000)+1).Scan(&wd.ID, &wd.RandomNumber)
+	if err != nil {
+		w.appendResponse("500 Internal Server Error", textPlain, []byte("Failed to read world from DB: "+err.Error()))
+		return
+	}
+
+	// Marshal can't fail for struct with integer fields.
+	body, _ := json.Marshal(wd)
+	w.appendResponse("200 OK", applicationJSON, body)
+}
+
+func fortunesQuery(dw *dbWorker, w *response) {
+	rows, _ := db.Query(context.Background(), "SELECT id, message FROM fortunes")
+	fortunes, err := pgx.AppendRows(dw.fortunes[:0], rows, pgx.RowToStructByPos[fortune])
+	if err != nil {
+		w.appendResponse("500 Internal Server Error", textPlain, []byte("Failed to read fortunes from DB: "+err.Error()))
+		return
+	}
+	fortunes = append(fortunes, fortune{ID: len(fortunes) + 1, Message: "Additional fortune added at request time."})
+	dw.fortunes = fortunes
+
+	sort.Slice(fortunes, func(i, j int) bool {
+		return fortunes[i].Message < fortunes[j].Message
+	})
+
+	dw.html.Reset()
+	if err := tmpl.ExecuteTemplate(&dw.html, "fortunes.tmpl", fortunes); err != nil {
+		w.appendResponse("500 Internal Server Error", textPlain, []byte("Failed to execute fortunes template: "+err.Error()))
+		return
+	}
+	w.appendResponse("200 OK", textHTML, dw.html.Bytes())
+}
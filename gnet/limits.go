@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// limits bound memory a single connection may hold; clients that exceed them are closed.
+type limits struct {
+	// maxPipeline is the number of requests handled in one OnTraffic call and the number of responses that may wait for database.
+	maxPipeline int
+
+	// maxOutput is the number of response bytes that may wait to be written. Input is not processed while output is full, and unprocessed input is limited to the same size.
+	maxOutput int
+
+	// maxHeaderSize bounds request line and headers, as well as trailers of chunked body.
+	maxHeaderSize int
+
+	// maxBody bounds decoded request body. Encoded chunked body may also take up to maxChunkLine bytes of framing per chunk.
+	maxBody int
+}
+
+// pausedConns are connections, that stopped processing input because their output is full. gnet doesn't report when output drains, so they are woken up by OnTick to check it.
+type pausedConns struct {
+	sync.Mutex
+	conns map[gnet.Conn]struct{}
+}
+
+func (p *pausedConns) add(c gnet.Conn) {
+	p.Lock()
+	if p.conns == nil {
+		p.conns = make(map[gnet.Conn]struct{})
+	}
+	p.conns[c] = struct{}{}
+	p.Unlock()
+}
+
+// wake wakes all paused connections; those that are still full pause again.
+func (p *pausedConns) wake() {
+	p.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.Unlock()
+
+	for c := range conns {
+		_ = c.Wake(nil)
+	}
+}
+
+// full reports whether connection has too much output pending, either in gnet buffers or waiting for database.
+func (hs *httpServer) full(c gnet.Conn, hc *httpCodec) bool {
+	if c.OutboundBuffered()+len(hc.out.buf) >= hs.limits.maxOutput {
+		return true
+	}
+
+	hc.mu.Lock()
+	queued := len(hc.queue)
+	hc.mu.Unlock()
+	return queued >= hs.limits.maxPipeline
+}
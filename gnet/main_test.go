@@ -0,0 +1,554 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// testConn is gnet.Conn that keeps input and output in memory. Writes issued with AsyncWrite are recorded and their callbacks run once flush is called, like they would on event loop.
+type testConn struct {
+	gnet.Conn
+
+	ctx      interface{}
+	in       []byte
+	out      []byte
+	outbound int
+
+	written   []string
+	callbacks []gnet.AsyncCallback
+	woken     int
+	closed    bool
+}
+
+func (c *testConn) Context() interface{}          { return c.ctx }
+func (c *testConn) SetContext(ctx interface{})    { c.ctx = ctx }
+func (c *testConn) Peek(n int) ([]byte, error)    { return c.in, nil }
+func (c *testConn) OutboundBuffered() int         { return c.outbound }
+func (c *testConn) Wake(gnet.AsyncCallback) error { c.woken++; return nil }
+
+func (c *testConn) Discard(n int) (int, error) {
+	if n < 0 || n > len(c.in) {
+		n = len(c.in)
+	}
+	c.in = c.in[n:]
+	return n, nil
+}
+
+func (c *testConn) Write(buf []byte) (int, error) {
+	c.out = append(c.out, buf...)
+	return len(buf), nil
+}
+
+func (c *testConn) AsyncWrite(buf []byte, callback gnet.AsyncCallback) error {
+	c.written = append(c.written, string(buf))
+	c.callbacks = append(c.callbacks, callback)
+	return nil
+}
+
+func (c *testConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *testConn) flush() {
+	callbacks := c.callbacks
+	c.callbacks = nil
+	for _, callback := range callbacks {
+		_ = callback(c, nil)
+	}
+}
+
+func newTestServer() *httpServer {
+	hs := &httpServer{limits: limits{maxPipeline: 8, maxOutput: 4096, maxHeaderSize: 512, maxBody: 64}}
+	hs.handle("/plaintext", plaintextHandler)
+	hs.handle("/json", jsonHandler)
+	return hs
+}
+
+func newTestConn(hs *httpServer) *testConn {
+	c := new(testConn)
+	hs.OnOpen(c)
+	return c
+}
+
+// serve passes input to OnTraffic, as if it has just been read from socket, and returns everything written since the last call.
+func (c *testConn) serve(t *testing.T, hs *httpServer, input string) (string, gnet.Action) {
+	t.Helper()
+
+	c.in = append(c.in, input...)
+	action := hs.OnTraffic(c)
+	out := string(c.out)
+	c.out = c.out[:0]
+	return out, action
+}
+
+func (c *testConn) mustServe(t *testing.T, hs *httpServer, input string) string {
+	t.Helper()
+
+	out, action := c.serve(t, hs, input)
+	if action != gnet.None {
+		t.Fatalf("Expected connection to stay open, got action %v", action)
+	}
+	return out
+}
+
+// splitResponses splits output into responses, using Content-Length to find where each of them ends. Responses to HEAD have no body, so heads marks which responses answer HEAD requests.
+func splitResponses(t *testing.T, out string, heads ...bool) []string {
+	t.Helper()
+
+	var responses []string
+	for i := 0; len(out) > 0; i++ {
+		end := strings.Index(out, "\r\n\r\n")
+		if end == -1 {
+			t.Fatalf("Incomplete response head in %q", out)
+		}
+		end += len("\r\n\r\n")
+
+		if i >= len(heads) || !heads[i] {
+			n, err := strconv.Atoi(headerValue(out[:end], "Content-Length"))
+			if err != nil {
+				t.Fatalf("Invalid Content-Length in %q", out[:end])
+			}
+			end += n
+		}
+		if end > len(out) {
+			t.Fatalf("Incomplete response body in %q", out)
+		}
+		responses = append(responses, out[:end])
+		out = out[end:]
+	}
+	return responses
+}
+
+// headerValue returns value of the first header with given name in response.
+func headerValue(response, name string) string {
+	head, _, _ := strings.Cut(response, "\r\n\r\n")
+	for _, line := range strings.Split(head, "\r\n")[1:] {
+		if key, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(key, name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func body(response string) string {
+	_, b, _ := strings.Cut(response, "\r\n\r\n")
+	return b
+}
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		head    bool
+		status  string
+		body    string
+		allow   string
+	}{
+		{"Get", get("/plaintext"), false, "200 OK", string(helloWorld), ""},
+		{"Head", "HEAD /plaintext HTTP/1.1\r\nHost: localhost\r\n\r\n", true, "200 OK", "", ""},
+		{"HeadNotFound", "HEAD /missing HTTP/1.1\r\nHost: localhost\r\n\r\n", true, "404 Not Found", "", ""},
+		{"Post", "POST /json HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\n{}", false, "405 Method Not Allowed", "405 Method Not Allowed\n", "GET, HEAD"},
+		{"Delete", "DELETE /plaintext HTTP/1.1\r\nHost: localhost\r\n\r\n", false, "405 Method Not Allowed", "405 Method Not Allowed\n", "GET, HEAD"},
+		{"PostNotFound", "POST /missing HTTP/1.1\r\nHost: localhost\r\n\r\n", false, "404 Not Found", "404 Not Found\n", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hs := newTestServer()
+			c := newTestConn(hs)
+
+			// Response after the tested one shows that nothing but its head was written for HEAD.
+			out := c.mustServe(t, hs, test.request+get("/plaintext"))
+			responses := splitResponses(t, out, test.head)
+			if len(responses) != 2 {
+				t.Fatalf("Expected 2 responses, got %d: %q", len(responses), out)
+			}
+
+			resp := responses[0]
+			if !strings.HasPrefix(resp, "HTTP/1.1 "+test.status+"\r\n") {
+				t.Errorf("Expected status %q, got %q", test.status, resp)
+			}
+			if body(resp) != test.body {
+				t.Errorf("Expected body %q, got %q", test.body, body(resp))
+			}
+			if allow := headerValue(resp, "Allow"); allow != test.allow {
+				t.Errorf("Expected Allow %q, got %q", test.allow, allow)
+			}
+			if test.head {
+				// HEAD gets Content-Length of the body GET would get.
+				get := splitResponses(t, c.mustServe(t, hs, strings.Replace(test.request, "HEAD", "GET", 1)))[0]
+				if length := headerValue(resp, "Content-Length"); length != headerValue(get, "Content-Length") {
+					t.Errorf("Expected Content-Length %q, got %q", headerValue(get, "Content-Length"), length)
+				}
+			}
+			if body(responses[1]) != string(helloWorld) {
+				t.Errorf("Expected response to the next request, got %q", responses[1])
+			}
+		})
+	}
+}
+
+func TestRouting(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		status      string
+		contentType string
+		body        string
+	}{
+		{"Plaintext", "/plaintext", "200 OK", textPlain, string(helloWorld)},
+		{"PlaintextQuery", "/plaintext?a=1", "200 OK", textPlain, string(helloWorld)},
+		{"JSON", "/json", "200 OK", applicationJSON, `{"message":"Hello, World!"}`},
+		{"NotFound", "/missing", "404 Not Found", textPlain, "404 Not Found\n"},
+		{"TrailingSlash", "/plaintext/", "404 Not Found", textPlain, "404 Not Found\n"},
+		{"Root", "/", "404 Not Found", textPlain, "404 Not Found\n"},
+	}
+
+	hs := newTestServer()
+	c := newTestConn(hs)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responses := splitResponses(t, c.mustServe(t, hs, get(test.path)))
+			if len(responses) != 1 {
+				t.Fatalf("Expected single response, got %q", responses)
+			}
+
+			resp := responses[0]
+			if !strings.HasPrefix(resp, "HTTP/1.1 "+test.status+"\r\n") {
+				t.Errorf("Expected status %q, got %q", test.status, resp)
+			}
+			if contentType := headerValue(resp, "Content-Type"); contentType != test.contentType {
+				t.Errorf("Expected Content-Type %q, got %q", test.contentType, contentType)
+			}
+			if body(resp) != test.body {
+				t.Errorf("Expected body %q, got %q", test.body, body(resp))
+			}
+		})
+	}
+}
+
+func TestOrderedWrites(t *testing.T) {
+	c := new(testConn)
+	hc := new(httpCodec)
+
+	if w := hc.next(false); w != &hc.out {
+		t.Fatalf("Expected response to go directly into output when nothing is queued")
+	}
+
+	db1 := hc.next(true)
+	db2 := hc.next(true)
+	plain := hc.next(false)
+	if plain == &hc.out {
+		t.Fatalf("Expected response to be queued behind database responses")
+	}
+	db1.buf = append(db1.buf, "1"...)
+	db2.buf = append(db2.buf, "2"...)
+	plain.buf = append(plain.buf, "3"...)
+
+	hc.complete(c, plain)
+	hc.complete(c, db2)
+	if len(c.written) != 0 {
+		t.Fatalf("Expected nothing to be written before the first response is done, got %q", c.written)
+	}
+
+	hc.complete(c, db1)
+	if len(c.written) != 1 || c.written[0] != "123" {
+		t.Fatalf("Expected responses to be written together in request order, got %q", c.written)
+	}
+	if len(hc.queue) != 0 {
+		t.Fatalf("Expected queue to be empty, got %d responses", len(hc.queue))
+	}
+
+	if w := hc.next(false); w == &hc.out {
+		t.Fatalf("Expected response to be queued while earlier write is in flight")
+	}
+	hc.queue = hc.queue[:0]
+
+	c.flush()
+	if w := hc.next(false); w != &hc.out {
+		t.Fatalf("Expected response to go directly into output once writes are complete")
+	}
+	if c.closed {
+		t.Fatalf("Expected connection to stay open")
+	}
+}
+
+func TestOrderedWritesClose(t *testing.T) {
+	c := new(testConn)
+	hc := new(httpCodec)
+
+	first := hc.next(true)
+	last := hc.next(true)
+	after := hc.next(true)
+	first.buf = append(first.buf, "1"...)
+	first.close = true
+	last.buf = append(last.buf, "2"...)
+
+	hc.complete(c, last)
+	hc.complete(c, after)
+	hc.complete(c, first)
+	if len(c.written) != 1 || c.written[0] != "1" {
+		t.Fatalf("Expected only responses up to the closing one to be written, got %q", c.written)
+	}
+	if c.closed {
+		t.Fatalf("Expected connection to be closed only after write completes")
+	}
+
+	c.flush()
+	if !c.closed {
+		t.Fatalf("Expected connection to be closed after closing response is written")
+	}
+}
+
+// testQuery stands in for database query, so tests don't need PostgreSQL.
+func testQuery(dw *dbWorker, w *response) {
+	w.appendResponse("200 OK", applicationJSON, []byte(`{"id":1}`))
+}
+
+func newTestDatabaseServer(queueSize int) *httpServer {
+	hs := newTestServer()
+	hs.handleQuery("/db", testQuery)
+	// Jobs are run by runJobs, so the test decides when database responses complete.
+	hs.jobs = make(chan job, queueSize)
+	return hs
+}
+
+// runJobs runs all submitted jobs the way database workers do.
+func (hs *httpServer) runJobs() {
+	dw := new(dbWorker)
+	for len(hs.jobs) > 0 {
+		j := <-hs.jobs
+		j.query(dw, j.w)
+		j.hc.complete(j.c, j.w)
+	}
+}
+
+func TestDatabaseResponsesOrder(t *testing.T) {
+	hs := newTestDatabaseServer(4)
+	c := newTestConn(hs)
+
+	// Response to the first request is written right away; the last one has to wait for database response before it.
+	out := c.mustServe(t, hs, get("/plaintext")+get("/db")+get("/json"))
+	if responses := splitResponses(t, out); len(responses) != 1 || body(responses[0]) != string(helloWorld) {
+		t.Fatalf("Expected only the first response to be written, got %q", out)
+	}
+	if len(c.written) != 0 {
+		t.Fatalf("Expected nothing to be written before database responds, got %q", c.written)
+	}
+
+	hs.runJobs()
+	if len(c.written) != 1 {
+		t.Fatalf("Expected database response and the one after it to be written together, got %q", c.written)
+	}
+	responses := splitResponses(t, c.written[0])
+	if len(responses) != 2 || body(responses[0]) != `{"id":1}` || body(responses[1]) != `{"message":"Hello, World!"}` {
+		t.Fatalf("Expected database response followed by JSON one, got %q", responses)
+	}
+
+	// Until asynchronous write completes, new responses must not overtake it.
+	if out := c.mustServe(t, hs, get("/plaintext")); len(out) != 0 {
+		t.Fatalf("Expected response to be queued while write is in flight, got %q", out)
+	}
+	c.flush()
+	if len(c.written) != 2 || body(c.written[1]) != string(helloWorld) {
+		t.Fatalf("Expected queued response to be written after the previous one, got %q", c.written)
+	}
+	c.flush()
+	if out := c.mustServe(t, hs, get("/plaintext")); body(out) != string(helloWorld) {
+		t.Fatalf("Expected response to be written directly once queue is empty, got %q", out)
+	}
+}
+
+func TestDatabaseResponseClose(t *testing.T) {
+	hs := newTestDatabaseServer(4)
+	c := newTestConn(hs)
+
+	// Nothing after request that closes connection is handled.
+	c.mustServe(t, hs, "GET /db HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"+get("/plaintext"))
+	if len(hs.jobs) != 1 {
+		t.Fatalf("Expected single database job, got %d", len(hs.jobs))
+	}
+
+	hs.runJobs()
+	if len(c.written) != 1 || len(splitResponses(t, c.written[0])) != 1 {
+		t.Fatalf("Expected only database response to be written, got %q", c.written)
+	}
+	c.flush()
+	if !c.closed {
+		t.Fatalf("Expected connection to be closed after database response is written")
+	}
+}
+
+func TestDatabaseQueueFull(t *testing.T) {
+	hs := newTestDatabaseServer(1)
+	c := newTestConn(hs)
+
+	c.mustServe(t, hs, get("/db")+get("/db")+get("/plaintext"))
+	if len(c.written) != 0 {
+		t.Fatalf("Expected rejected request to wait for database response before it, got %q", c.written)
+	}
+
+	hs.runJobs()
+	if len(c.written) != 1 {
+		t.Fatalf("Expected all responses to be written together, got %q", c.written)
+	}
+	responses := splitResponses(t, c.written[0])
+	if len(responses) != 3 {
+		t.Fatalf("Expected 3 responses, got %q", responses)
+	}
+	if !strings.HasPrefix(responses[0], "HTTP/1.1 200 OK\r\n") {
+		t.Errorf("Expected the first request to be served, got %q", responses[0])
+	}
+	if !strings.HasPrefix(responses[1], "HTTP/1.1 503 Service Unavailable\r\n") {
+		t.Errorf("Expected request that doesn't fit into job queue to be rejected with 503, got %q", responses[1])
+	}
+	if body(responses[2]) != string(helloWorld) {
+		t.Errorf("Expected the last request to be served, got %q", responses[2])
+	}
+}
+
+func TestDate(t *testing.T) {
+	defer updateDate(time.Now())
+
+	// Example from RFC 9110, section 5.6.7, given in non-UTC zone.
+	updateDate(time.Date(1994, time.November, 6, 9, 49, 37, 0, time.FixedZone("CET", 3600)))
+	if d := string(currentDate()); d != "Sun, 06 Nov 1994 08:49:37 GMT" {
+		t.Fatalf("Expected IMF-fixdate, got %q", d)
+	}
+
+	hs := newTestServer()
+	c := newTestConn(hs)
+	out := c.mustServe(t, hs, get("/plaintext"))
+	if d := headerValue(out, "Date"); d != "Sun, 06 Nov 1994 08:49:37 GMT" {
+		t.Errorf("Expected Date header to be the current date, got %q", d)
+	}
+	if server := headerValue(out, "Server"); server != serverName {
+		t.Errorf("Expected Server header %q, got %q", serverName, server)
+	}
+
+	// OnTick refreshes date once second changes.
+	hs.OnTick()
+	d, err := time.Parse(http.TimeFormat, string(currentDate()))
+	if err != nil {
+		t.Fatalf("Failed to parse date %q: %v", currentDate(), err)
+	}
+	if diff := time.Since(d); diff < -time.Second || diff > 2*time.Second {
+		t.Errorf("Expected date to be refreshed, got %q", currentDate())
+	}
+}
+
+func TestMaxPipeline(t *testing.T) {
+	hs := newTestServer()
+	c := newTestConn(hs)
+
+	requests := strings.Repeat(get("/plaintext"), hs.limits.maxPipeline+2)
+	if responses := splitResponses(t, c.mustServe(t, hs, requests)); len(responses) != hs.limits.maxPipeline {
+		t.Fatalf("Expected %d responses, got %d", hs.limits.maxPipeline, len(responses))
+	}
+	if c.woken != 1 {
+		t.Fatalf("Expected connection to wake itself up to handle the rest, got %d wake ups", c.woken)
+	}
+
+	// Wake up calls OnTraffic again with the rest of input.
+	if responses := splitResponses(t, c.mustServe(t, hs, "")); len(responses) != 2 {
+		t.Fatalf("Expected the rest of requests to be handled, got %d responses", len(responses))
+	}
+	if len(c.in) != 0 {
+		t.Fatalf("Expected all input to be consumed, %q is left", c.in)
+	}
+}
+
+func TestMaxOutput(t *testing.T) {
+	hs := newTestServer()
+	c := newTestConn(hs)
+
+	// Input is not processed while output is full, connection waits for OnTick to wake it up.
+	c.outbound = hs.limits.maxOutput
+	if out := c.mustServe(t, hs, get("/plaintext")); len(out) != 0 {
+		t.Fatalf("Expected no output while output is full, got %q", out)
+	}
+	if _, ok := hs.paused.conns[c]; !ok {
+		t.Fatalf("Expected connection to be paused")
+	}
+
+	hs.paused.wake()
+	if c.woken != 1 || len(hs.paused.conns) != 0 {
+		t.Fatalf("Expected paused connection to be woken up once, got %d wake ups and %d paused connections", c.woken, len(hs.paused.conns))
+	}
+	if out := c.mustServe(t, hs, ""); len(out) != 0 {
+		t.Fatalf("Expected no output while output is still full, got %q", out)
+	}
+	if _, ok := hs.paused.conns[c]; !ok {
+		t.Fatalf("Expected connection to be paused again")
+	}
+
+	c.outbound = 0
+	hs.paused.wake()
+	if out := c.mustServe(t, hs, ""); body(out) != string(helloWorld) {
+		t.Fatalf("Expected request to be handled once output drains, got %q", out)
+	}
+}
+
+func TestMaxOutputInput(t *testing.T) {
+	hs := newTestServer()
+	c := newTestConn(hs)
+
+	// Client that doesn't read responses can't make server keep more input than output.
+	c.outbound = hs.limits.maxOutput
+	requests := strings.Repeat(get("/plaintext"), hs.limits.maxOutput/len(get("/plaintext"))+1)
+	if _, action := c.serve(t, hs, requests); action != gnet.Close {
+		t.Fatalf("Expected connection to be closed, got action %v", action)
+	}
+}
+
+func TestIncompleteInput(t *testing.T) {
+	const chunked = "POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"
+
+	tests := []struct {
+		name   string
+		parts  []string
+		status string
+	}{
+		// Encoded body is larger than maxHeaderSize and maxBody together, but still valid.
+		{"ManySmallChunks", []string{chunked, strings.Repeat("1;ext=value\r\na\r\n", 64), "0\r\n\r\n"}, "405 Method Not Allowed"},
+		{"HeaderTooLarge", []string{"GET /plaintext HTTP/1.1\r\n", strings.Repeat("X-Header: value\r\n", 32)}, "431 Request Header Fields Too Large"},
+		{"BodyTooLarge", []string{chunked + "20\r\n" + strings.Repeat("a", 32) + "\r\n", "21\r\n"}, "413 Content Too Large"},
+		{"ChunkLineTooLong", []string{chunked, "1;ext=" + strings.Repeat("x", 64)}, "400 Bad Request"},
+		{"TrailersTooLarge", []string{chunked + "1\r\na\r\n0\r\n", strings.Repeat("X-Trailer: value\r\n", 32)}, "431 Request Header Fields Too Large"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hs := newTestServer()
+			c := newTestConn(hs)
+
+			var out string
+			var action gnet.Action
+			for _, part := range test.parts {
+				if action != gnet.None {
+					t.Fatalf("Expected connection to stay open until the last part, got action %v", action)
+				}
+				var o string
+				o, action = c.serve(t, hs, part)
+				out += o
+			}
+
+			if !strings.HasPrefix(out, "HTTP/1.1 "+test.status+"\r\n") {
+				t.Fatalf("Expected status %q, got %q", test.status, out)
+			}
+			if test.status == "405 Method Not Allowed" {
+				if action != gnet.None {
+					t.Errorf("Expected connection to stay open, got action %v", action)
+				}
+			} else if action != gnet.Close {
+				t.Errorf("Expected connection to be closed, got action %v", action)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	// errIncomplete means that data ends before the request does; the caller keeps it and tries again once more bytes arrive.
+	errIncomplete     = errors.New("incomplete request")
+	errMalformed      = errors.New("malformed request")
+	errNotImplemented = errors.New("unsupported transfer encoding")
+	errHeaderTooLarge = errors.New("request header too large")
+	errBodyTooLarge   = errors.New("request body too large")
+)
+
+var crlf = []byte("\r\n")
+
+type header struct {
+	name  []byte
+	value []byte
+}
+
+// request holds slices into the inbound buffer, so it is only valid until parsed bytes are discarded.
+type request struct {
+	method  []byte
+	path    []byte
+	query   []byte
+	proto   []byte
+	headers []header
+
+	contentLength int
+	chunked       bool
+	keepAlive     bool
+	body          []byte
+}
+
+func (req *request) reset() {
+	req.method = nil
+	req.path = nil
+	req.query = nil
+	req.proto = nil
+	req.headers = req.headers[:0]
+	req.contentLength = 0
+	req.chunked = false
+	req.keepAlive = false
+	req.body = nil
+}
+
+// header returns value of the first header with the given name.
+func (req *request) header(name string) []byte {
+	for i := range req.headers {
+		if equalFold(req.headers[i].name, name) {
+			return req.headers[i].value
+		}
+	}
+	return nil
+}
+
+func equalFold(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := 0; i < len(b); i++ {
+		if lower(b[i]) != lower(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// isTokenChar reports whether c may appear in methods and header names (RFC 9110, section 5.6.2).
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return bytes.IndexByte([]byte("!#$%&'*+-.^_`|~"), c) != -1
+}
+
+func isToken(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if !isTokenChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func trimSpace(b []byte) []byte {
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\t') {
+		b = b[1:]
+	}
+	for len(b) > 0 && (b[len(b)-1] == ' ' || b[len(b)-1] == '\t') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// hasToken reports whether comma-separated list contains token, ignoring case.
+func hasToken(list []byte, token string) bool {
+	for len(list) > 0 {
+		var elem []byte
+		if i := bytes.IndexByte(list, ','); i != -1 {
+			elem, list = list[:i], list[i+1:]
+		} else {
+			elem, list = list, nil
+		}
+		if equalFold(trimSpace(elem), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRequestLine(req *request, line []byte) error {
+	sp := bytes.IndexByte(line, ' ')
+	if sp == -1 {
+		return errMalformed
+	}
+	req.method, line = line[:sp], line[sp+1:]
+
+	sp = bytes.IndexByte(line, ' ')
+	if sp == -1 {
+		return errMalformed
+	}
+	target := line[:sp]
+	req.proto = line[sp+1:]
+
+	if !isToken(req.method) || len(target) == 0 {
+		return errMalformed
+	}
+	for _, c := range target {
+		if c <= ' ' || c == 0x7f {
+			return errMalformed
+		}
+	}
+	if q := bytes.IndexByte(target, '?'); q != -1 {
+		req.path, req.query = target[:q], target[q+1:]
+	} else {
+		req.path = target
+	}
+
+	switch string(req.proto) {
+	case "HTTP/1.1":
+		req.keepAlive = true
+	case "HTTP/1.0":
+		req.keepAlive = false
+	default:
+		return errMalformed
+	}
+	return nil
+}
+
+func parseContentLength(value []byte) (int, error) {
+	if len(value) == 0 || len(value) > 9 {
+		return 0, errMalformed
+	}
+	var n int
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return 0, errMalformed
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, nil
+}
+
+// parseHeaders parses header lines, each of them terminated by CRLF, and validates message framing.
+func parseHeaders(req *request, data []byte) error {
+	var hasContentLength, hasHost bool
+
+	for len(data) > 0 {
+		end := bytes.Index(data, crlf)
+		line := data[:end]
+		data = data[end+len(crlf):]
+
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 || !isToken(line[:colon]) {
+			// Also rejects obsolete line folding, since continuation lines start with whitespace.
+			return errMalformed
+		}
+		h := header{name: line[:colon], value: trimSpace(line[colon+1:])}
+		for _, c := range h.value {
+			if (c < ' ' && c != '\t') || c == 0x7f {
+				return errMalformed
+			}
+		}
+		req.headers = append(req.headers, h)
+
+		switch {
+		case equalFold(h.name, "Content-Length"):
+			n, err := parseContentLength(h.value)
+			if err != nil || (hasContentLength && n != req.contentLength) {
+				return errMalformed
+			}
+			req.contentLength = n
+			hasContentLength = true
+		case equalFold(h.name, "Transfer-Encoding"):
+			if !equalFold(h.value, "chunked") {
+				return errNotImplemented
+			}
+			req.chunked = true
+		case equalFold(h.name, "Connection"):
+			if hasToken(h.value, "close") {
+				req.keepAlive = false
+			} else if hasToken(h.value, "keep-alive") {
+				req.keepAlive = true
+			}
+		case equalFold(h.name, "Host"):
+			hasHost = true
+		}
+	}
+
+	// Requests with both lengths are a classic way to smuggle requests past proxies.
+	if req.chunked && hasContentLength {
+		return errMalformed
+	}
+	if !hasHost && string(req.proto) == "HTTP/1.1" {
+		return errMalformed
+	}
+	return nil
+}
+
+// hasBareLF reports whether data[from:to] contains LF that isn't preceded by CR. Such line endings are rejected instead of being waited on, since CRLF that ends headers would never arrive.
+func hasBareLF(data []byte, from, to int) bool {
+	for i := from; i < to; i++ {
+		j := bytes.IndexByte(data[i:to], '\n')
+		if j == -1 {
+			return false
+		}
+		i += j
+		if i == 0 || data[i-1] != '\r' {
+			return true
+		}
+	}
+	return false
+}
+
+// lineEnd returns index of CRLF that ends the first line of data.
+func lineEnd(data []byte) (int, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i == -1 {
+		return 0, errIncomplete
+	}
+	if i == 0 || data[i-1] != '\r' {
+		return 0, errMalformed
+	}
+	return i - 1, nil
+}
+
+// maxChunkLine bounds chunk size line, including extensions and CRLF. Together with maxBody it bounds encoded chunked body: every chunk but the last one carries at least one byte of data.
+const maxChunkLine = 64
+
+// boundedLineEnd is lineEnd for lines that may not be longer than limit, including CRLF. Longer lines are reported with tooLong as soon as limit bytes have arrived without LF.
+func boundedLineEnd(data []byte, limit int, tooLong error) (int, error) {
+	end, err := lineEnd(data[:min(len(data), limit)])
+	if err == errIncomplete && len(data) >= limit {
+		return 0, tooLong
+	}
+	return end, err
+}
+
+func parseChunkSize(line []byte) (int, error) {
+	if i := bytes.IndexByte(line, ';'); i != -1 {
+		line = line[:i]
+	}
+	line = trimSpace(line)
+	if len(line) == 0 || len(line) > 7 {
+		return 0, errMalformed
+	}
+
+	var n int
+	for _, c := range line {
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c -= 'a' - 10
+		case c >= 'A' && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, errMalformed
+		}
+		n = n<<4 | int(c)
+	}
+	return n, nil
+}
+
+// decodeChunked appends decoded chunked body to dst and returns number of bytes it occupies in data. Chunk extensions and trailers are skipped. Bodies longer than maxBody are reported as soon as chunk that exceeds it is announced, chunk size lines longer than maxChunkLine and trailers longer than maxTrailers as soon as that many bytes of them arrive, so incomplete body never takes more than that.
+func decodeChunked(data []byte, dst []byte, maxBody, maxTrailers int) (int, []byte, error) {
+	var pos int
+	var length int
+
+	for {
+		end, err := boundedLineEnd(data[pos:], maxChunkLine, errMalformed)
+		if err != nil {
+			return 0, dst, err
+		}
+		size, err := parseChunkSize(data[pos : pos+end])
+		if err != nil {
+			return 0, dst, err
+		}
+		pos += end + len(crlf)
+
+		length += size
+		if length > maxBody {
+			return 0, dst, errBodyTooLarge
+		}
+
+		if size == 0 {
+			trailers := pos
+			for {
+				end, err := boundedLineEnd(data[pos:], maxTrailers-(pos-trailers), errHeaderTooLarge)
+				if err != nil {
+					return 0, dst, err
+				}
+				pos += end + len(crlf)
+				if end == 0 {
+					return pos, dst, nil
+				}
+			}
+		}
+
+		if len(data)-pos < size+len(crlf) {
+			return 0, dst, errIncomplete
+		}
+		if !bytes.Equal(data[pos+size:pos+size+len(crlf)], crlf) {
+			return 0, dst, errMalformed
+		}
+		dst = append(dst, data[pos:pos+size]...)
+		pos += size + len(crlf)
+	}
+}
+
+// parse parses one request from the beginning of data and returns number of bytes it occupies. Partial requests are reported with errIncomplete; hc remembers how far it has looked for the end of headers, so slowly arriving requests are not rescanned from the start.
+func (hc *httpCodec) parse(data []byte) (int, error) {
+	// Empty lines before request line are allowed by RFC 9112, section 2.2.
+	var skipped int
+	for bytes.HasPrefix(data[skipped:], crlf) {
+		skipped += len(crlf)
+	}
+	data = data[skipped:]
+
+	from := max(hc.scanned-skipped-3, 0)
+	idx := bytes.Index(data[from:], []byte("\r\n\r\n"))
+	to := len(data)
+	if idx != -1 {
+		to = from + idx + 4
+	}
+	if hasBareLF(data, from, to) {
+		return 0, errMalformed
+	}
+	if idx == -1 {
+		// Counts skipped lines as well, so they can't grow inbound buffer without limit.
+		if skipped+len(data) > hc.maxHeaderSize {
+			return 0, errHeaderTooLarge
+		}
+		hc.scanned = skipped + len(data)
+		return 0, errIncomplete
+	}
+	headerEnd := from + idx + 4
+	if headerEnd > hc.maxHeaderSize {
+		return 0, errHeaderTooLarge
+	}
+
+	req := &hc.req
+	req.reset()
+	lineEnd := bytes.Index(data, crlf)
+	if err := parseRequestLine(req, data[:lineEnd]); err != nil {
+		return 0, err
+	}
+	if err := parseHeaders(req, data[lineEnd+len(crlf):headerEnd-len(crlf)]); err != nil {
+		return 0, err
+	}
+
+	n := headerEnd
+	if req.chunked {
+		var consumed int
+		var err error
+
+		consumed, hc.body, err = decodeChunked(data[headerEnd:], hc.body[:0], hc.maxBody, hc.maxHeaderSize)
+		if err != nil {
+			if err == errIncomplete {
+				hc.scanned = skipped + headerEnd - 4
+			}
+			return 0, err
+		}
+		req.body = hc.body
+		n += consumed
+	} else {
+		if req.contentLength > hc.maxBody {
+			return 0, errBodyTooLarge
+		}
+		if len(data)-headerEnd < req.contentLength {
+			hc.scanned = skipped + headerEnd - 4
+			return 0, errIncomplete
+		}
+		req.body = data[headerEnd : headerEnd+req.contentLength]
+		n += req.contentLength
+	}
+
+	hc.scanned = 0
+	return skipped + n, nil
+}
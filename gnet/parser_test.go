@@ -0,0 +1,195 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// parsed is what parseAll reports for every request, either its method, path and body or error.
+type parsed struct {
+	method string
+	path   string
+	body   string
+	err    error
+}
+
+// parseAll feeds parts to hc one by one, the way they would arrive from socket, and parses every complete request.
+func parseAll(hc *httpCodec, parts ...string) []parsed {
+	var results []parsed
+	var buf []byte
+
+	for _, part := range parts {
+		buf = append(buf, part...)
+		for len(buf) > 0 {
+			n, err := hc.parse(buf)
+			if err == errIncomplete {
+				break
+			}
+			if err != nil {
+				return append(results, parsed{err: err})
+			}
+			results = append(results, parsed{method: string(hc.req.method), path: string(hc.req.path), body: string(hc.req.body)})
+			buf = buf[n:]
+		}
+	}
+	return results
+}
+
+func get(path string) string {
+	return "GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []string
+		want  []parsed
+	}{
+		{
+			name:  "Single",
+			parts: []string{get("/plaintext")},
+			want:  []parsed{{method: "GET", path: "/plaintext"}},
+		},
+		{
+			name:  "Pipelined",
+			parts: []string{get("/plaintext") + get("/json") + get("/db")},
+			want:  []parsed{{method: "GET", path: "/plaintext"}, {method: "GET", path: "/json"}, {method: "GET", path: "/db"}},
+		},
+		{
+			name:  "SplitHeaders",
+			parts: []string{"GET /json HTTP/1.1\r", "\nHost: loc", "alhost\r\n\r", "\n"},
+			want:  []parsed{{method: "GET", path: "/json"}},
+		},
+		{
+			name:  "SplitBetweenRequests",
+			parts: []string{get("/plaintext") + "GET /js", "on HTTP/1.1\r\nHost: localhost\r\n\r\n" + get("/db")},
+			want:  []parsed{{method: "GET", path: "/plaintext"}, {method: "GET", path: "/json"}, {method: "GET", path: "/db"}},
+		},
+		{
+			name:  "LeadingEmptyLines",
+			parts: []string{"\r\n\r\n" + get("/plaintext")},
+			want:  []parsed{{method: "GET", path: "/plaintext"}},
+		},
+		{
+			name:  "ContentLength",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello", " world" + get("/json")},
+			want:  []parsed{{method: "POST", path: "/plaintext", body: "hello world"}, {method: "GET", path: "/json"}},
+		},
+		{
+			name:  "Chunked",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel", "lo\r\n6;ext=1\r\n world\r\n0\r\nX-Trailer: 1\r\n\r\n" + get("/json")},
+			want:  []parsed{{method: "POST", path: "/plaintext", body: "hello world"}, {method: "GET", path: "/json"}},
+		},
+		{
+			name:  "DuplicateContentLength",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello"},
+			want:  []parsed{{method: "POST", path: "/plaintext", body: "hello"}},
+		},
+		{
+			name:  "ConflictingContentLength",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!"},
+			want:  []parsed{{err: errMalformed}},
+		},
+		{
+			name:  "ContentLengthAndChunked",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+			want:  []parsed{{err: errMalformed}},
+		},
+		{
+			name:  "UnsupportedTransferEncoding",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip\r\n\r\n"},
+			want:  []parsed{{err: errNotImplemented}},
+		},
+		{
+			name:  "MalformedChunk",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"},
+			want:  []parsed{{err: errMalformed}},
+		},
+		{
+			name:  "HeaderTooLarge",
+			parts: []string{"GET /plaintext HTTP/1.1\r\nHost: localhost\r\nX-Padding: " + strings.Repeat("a", 1024)},
+			want:  []parsed{{err: errHeaderTooLarge}},
+		},
+		{
+			name:  "HeaderTooLargeSplit",
+			parts: []string{"GET /plaintext HTTP/1.1\r\n", strings.Repeat("X-Header: value\r\n", 32), strings.Repeat("X-Header: value\r\n", 32), "\r\n"},
+			want:  []parsed{{err: errHeaderTooLarge}},
+		},
+		{
+			name:  "ContentLengthTooLarge",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nContent-Length: 65\r\n\r\n"},
+			want:  []parsed{{err: errBodyTooLarge}},
+		},
+		{
+			name:  "ChunkedTooLarge",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n20\r\n" + strings.Repeat("a", 32) + "\r\n", "21\r\n"},
+			want:  []parsed{{err: errBodyTooLarge}},
+		},
+		{
+			// Framing takes more than maxHeaderSize and maxBody together, but every chunk line is within its limit.
+			name:  "ManySmallChunks",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" + strings.Repeat("1;ext=value\r\na\r\n", 64), "0\r\n\r\n"},
+			want:  []parsed{{method: "POST", path: "/plaintext", body: strings.Repeat("a", 64)}},
+		},
+		{
+			name:  "ChunkLineTooLong",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n1;ext=" + strings.Repeat("x", 64) + "\r\na\r\n0\r\n\r\n"},
+			want:  []parsed{{err: errMalformed}},
+		},
+		{
+			name:  "ChunkLineTooLongIncomplete",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n1;ext=", strings.Repeat("x", 32), strings.Repeat("x", 32)},
+			want:  []parsed{{err: errMalformed}},
+		},
+		{
+			name:  "Trailers",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n1\r\na\r\n0\r\n", strings.Repeat("X-Trailer: value\r\n", 16), "\r\n"},
+			want:  []parsed{{method: "POST", path: "/plaintext", body: "a"}},
+		},
+		{
+			name:  "TrailersTooLarge",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n1\r\na\r\n0\r\n", strings.Repeat("X-Trailer: value\r\n", 16), strings.Repeat("X-Trailer: value\r\n", 16)},
+			want:  []parsed{{err: errHeaderTooLarge}},
+		},
+		{
+			name:  "MissingHost",
+			parts: []string{"GET /plaintext HTTP/1.1\r\n\r\n"},
+			want:  []parsed{{err: errMalformed}},
+		},
+		{
+			name:  "LFOnly",
+			parts: []string{"GET /plaintext HTTP/1.1\nHost: localhost\n\n"},
+			want:  []parsed{{err: errMalformed}},
+		},
+		{
+			name:  "LFOnlySplit",
+			parts: []string{"GET /plaintext HTTP/1.1\r\nHost: localhost\r", "\n\n"},
+			want:  []parsed{{err: errMalformed}},
+		},
+		{
+			name:  "LFOnlyAfterRequest",
+			parts: []string{get("/plaintext") + "\n" + get("/json")},
+			want:  []parsed{{method: "GET", path: "/plaintext"}, {err: errMalformed}},
+		},
+		{
+			name:  "LFOnlyChunked",
+			parts: []string{"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\n0\n\n"},
+			want:  []parsed{{err: errMalformed}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hc := &httpCodec{maxHeaderSize: 512, maxBody: 64}
+			got := parseAll(hc, test.parts...)
+			if len(got) != len(test.want) {
+				t.Fatalf("Expected %d results, got %d: %+v", len(test.want), len(got), got)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("Request %d: expected %+v, got %+v", i, test.want[i], got[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+var (
+	BodyTooLarge  = errors.New("request body is too large")
+	BodyMalformed = errors.New("malformed chunked request body")
+)
+
+func ParseChunkSize(line []byte) (int, error) {
+	if semicolon := bytes.IndexByte(line, ';'); semicolon != -1 {
+		line = line[:semicolon]
+	}
+	line = bytes.TrimRight(line, " \t")
+	if (len(line) == 0) || (len(line) > 8) {
+		return 0, BodyMalformed
+	}
+
+	var size int
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case (c >= '0') && (c <= '9'):
+			c -= '0'
+		case (c >= 'a') && (c <= 'f'):
+			c -= 'a' - 10
+		case (c >= 'A') && (c <= 'F'):
+			c -= 'A' - 10
+		default:
+			return 0, BodyMalformed
+		}
+		size = (size << 4) | int(c)
+	}
+	return size, nil
+}
+
+/* DecodeChunked appends chunked body at the beginning of data to dst and returns the number of bytes it occupies. Chunk extensions and trailers are ignored. */
+func DecodeChunked(data []byte, dst []byte, maxSize int) (int, []byte, error) {
+	var read int
+
+	for {
+		end := bytes.Index(data[read:], []byte("\r\n"))
+		if end == -1 {
+			return 0, nil, RequestIncomplete
+		}
+		size, err := ParseChunkSize(data[read : read+end])
+		if err != nil {
+			return 0, nil, err
+		}
+		read += end + len("\r\n")
+
+		if size == 0 {
+			for {
+				end := bytes.Index(data[read:], []byte("\r\n"))
+				if end == -1 {
+					return 0, nil, RequestIncomplete
+				}
+				read += end + len("\r\n")
+				if end == 0 {
+					return read, dst, nil
+				}
+			}
+		}
+
+		if len(dst)+size > maxSize {
+			return 0, nil, BodyTooLarge
+		}
+		if read+size+len("\r\n") > len(data) {
+			return 0, nil, RequestIncomplete
+		}
+		if (data[read+size] != '\r') || (data[read+size+1] != '\n') {
+			return 0, nil, BodyMalformed
+		}
+		dst = append(dst, data[read:read+size]...)
+		read += size + len("\r\n")
+	}
+}
+
+/* RequestIncomplete is returned by FrameRequest when data ends before the request does. */
+var RequestIncomplete = errors.New("incomplete request")
+
+/* RequestBytes returns input buffered in ctx that parser has not consumed yet. */
+func RequestBytes(ctx *http.Context) []byte {
+	return ctx.RequestBuffer.UnconsumedSlice()
+}
+
+/* Frame describes request found by FrameRequest. */
+type Frame struct {
+	/* Length is the number of bytes request occupies, including its body. */
+	Length int
+
+	/* HeadLength is the number of bytes request line and headers occupy, which is all parser consumes. */
+	HeadLength int
+
+	HasBody bool
+	Body    []byte
+
+	/* Close is set for requests after which connection must be closed: those with 'Connection: close' and all HTTP/1.0 ones. */
+	Close bool
+}
+
+/* HasToken reports whether comma-separated list contains token, ignoring case. */
+func HasToken(list []byte, token string) bool {
+	for len(list) > 0 {
+		var element []byte
+		if comma := bytes.IndexByte(list, ','); comma != -1 {
+			element, list = list[:comma], list[comma+1:]
+		} else {
+			element, list = list, nil
+		}
+		if strings.EqualFold(string(bytes.TrimSpace(element)), token) {
+			return true
+		}
+	}
+	return false
+}
+
+/* FrameRequest finds where request at the beginning of data ends and fills frame. Bodies, framed either by Content-Length or by chunked Transfer-Encoding, are decoded into dst. Errors are http.Error values ready to be sent to client, except for RequestIncomplete. */
+func FrameRequest(data []byte, dst []byte, frame *Frame) error {
+	var n int
+
+	*frame = Frame{}
+
+	/* Empty lines before request line are skipped by parser as well. */
+	for bytes.HasPrefix(data[n:], []byte("\r\n")) {
+		n += len("\r\n")
+	}
+
+	headersEnd := bytes.Index(data[n:], []byte("\r\n\r\n"))
+	if headersEnd == -1 {
+		return RequestIncomplete
+	}
+	headers := data[n : n+headersEnd+len("\r\n")]
+	n += headersEnd + len("\r\n\r\n")
+	frame.HeadLength = n
+
+	var transferEncoding, contentLength []byte
+	for len(headers) > 0 {
+		end := bytes.Index(headers, []byte("\r\n"))
+		line := headers[:end]
+		headers = headers[end+len("\r\n"):]
+
+		colon := bytes.IndexByte(line, ':')
+		if colon == -1 {
+			/* Request line; the rest of it is validated by parser. */
+			frame.Close = frame.Close || bytes.HasSuffix(line, []byte(" HTTP/1.0"))
+			continue
+		}
+		name, value := line[:colon], bytes.TrimSpace(line[colon+1:])
+		switch {
+		case strings.EqualFold(string(name), "Transfer-Encoding"):
+			transferEncoding = value
+		case strings.EqualFold(string(name), "Content-Length"):
+			if (contentLength != nil) && !bytes.Equal(contentLength, value) {
+				return http.Error{StatusCode: http.StatusBadRequest, DisplayMessage: "conflicting Content-Length values"}
+			}
+			contentLength = value
+		case strings.EqualFold(string(name), "Connection"):
+			frame.Close = frame.Close || HasToken(value, "close")
+		}
+	}
+
+	if transferEncoding != nil {
+		if contentLength != nil {
+			return http.Error{StatusCode: http.StatusBadRequest, DisplayMessage: "both Content-Length and Transfer-Encoding are specified"}
+		}
+		if !strings.EqualFold(string(transferEncoding), "chunked") {
+			return http.Error{StatusCode: http.StatusNotImplemented, DisplayMessage: "only chunked transfer encoding is supported"}
+		}
+
+		length, body, err := DecodeChunked(data[n:], dst, Config.MaxBodySize)
+		switch err {
+		case nil:
+			frame.Length = n + length
+			frame.HasBody = true
+			frame.Body = body
+			return nil
+		case RequestIncomplete:
+			return err
+		case BodyTooLarge:
+			return http.Error{StatusCode: http.StatusRequestEntityTooLarge, DisplayMessage: err.Error()}
+		default:
+			return http.Error{StatusCode: http.StatusBadRequest, DisplayMessage: err.Error()}
+		}
+	}
+
+	if contentLength == nil {
+		frame.Length = n
+		return nil
+	}
+	length, err := strconv.Atoi(string(contentLength))
+	if (err != nil) || (length < 0) {
+		return http.Error{StatusCode: http.StatusBadRequest, DisplayMessage: "invalid Content-Length"}
+	}
+	/* Reported before body arrives, so client doesn't have to send all of it. */
+	if length > Config.MaxBodySize {
+		return http.Error{StatusCode: http.StatusRequestEntityTooLarge, DisplayMessage: BodyTooLarge.Error()}
+	}
+	if len(data)-n < length {
+		return RequestIncomplete
+	}
+	frame.Length = n + length
+	frame.HasBody = true
+	frame.Body = append(dst, data[n:n+length]...)
+	return nil
+}
+
+/* ReadBody returns body of r, which ProcessRequests has already framed and decoded. Requests without Content-Length or Transfer-Encoding have no body at all and are rejected with 411. */
+func ReadBody(r *http.Request) ([]byte, error) {
+	if !r.Headers.Has("Content-Length") && !r.Headers.Has("Transfer-Encoding") {
+		return nil, http.Error{StatusCode: http.StatusLengthRequired, DisplayMessage: "request body requires Content-Length"}
+	}
+	return r.Body, nil
+}
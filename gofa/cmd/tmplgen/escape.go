@@ -0,0 +1,218 @@
+package main
+
+import "strings"
+
+type State int
+
+const (
+	StateText State = iota
+	StateTagName
+	StateTag
+	StateAttrName
+	StateAfterAttrName
+	StateBeforeValue
+	StateValue
+	StateComment
+	StateRawText
+)
+
+var StateNames = [...]string{
+	StateText:          "text",
+	StateTagName:       "tag name",
+	StateTag:           "tag",
+	StateAttrName:      "attribute name",
+	StateAfterAttrName: "attribute name",
+	StateBeforeValue:   "unquoted attribute value",
+	StateValue:         "attribute value",
+	StateComment:       "comment",
+	StateRawText:       "raw text element",
+}
+
+func (s State) String() string {
+	return StateNames[s]
+}
+
+/* Context tracks where in HTML document generated code is, the same way html/template does, but only far enough to tell whether value can be safely written with HTML escaping. */
+type Context struct {
+	State   State
+	Tag     string
+	Closing bool
+	Attr    string
+
+	/* Quote is 0 for unquoted attribute values. */
+	Quote byte
+
+	/* Tail keeps last bytes of comments and raw text elements to find their ends. */
+	Tail string
+}
+
+func IsSpace(c byte) bool {
+	return (c == ' ') || (c == '\t') || (c == '\n') || (c == '\r') || (c == '\f')
+}
+
+func IsNameByte(c byte) bool {
+	return ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')) || ((c >= '0') && (c <= '9')) || (c == '-') || (c == ':') || (c == '_')
+}
+
+func Lower(c byte) byte {
+	if (c >= 'A') && (c <= 'Z') {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func (c *Context) AppendTail(b byte) {
+	c.Tail += string(Lower(b))
+	if len(c.Tail) > 16 {
+		c.Tail = c.Tail[len(c.Tail)-16:]
+	}
+}
+
+func (c *Context) EndTag() {
+	if !c.Closing && ((c.Tag == "script") || (c.Tag == "style") || (c.Tag == "textarea")) {
+		c.State = StateRawText
+		c.Tail = ""
+		return
+	}
+	c.State = StateText
+}
+
+func (c *Context) Step(b byte) {
+	switch c.State {
+	case StateText:
+		if b == '<' {
+			c.State = StateTagName
+			c.Tag = ""
+			c.Closing = false
+		}
+	case StateTagName:
+		switch {
+		case (b == '/') && (len(c.Tag) == 0) && !c.Closing:
+			c.Closing = true
+		case (b == '-') && ((c.Tag == "!") || (c.Tag == "!-")):
+			c.Tag += "-"
+			if c.Tag == "!--" {
+				c.State = StateComment
+				c.Tail = ""
+			}
+		case ((b == '!') && (len(c.Tag) == 0)) || IsNameByte(b):
+			c.Tag += string(Lower(b))
+		case b == '>':
+			c.EndTag()
+		case len(c.Tag) == 0:
+			/* Stray '<' is just text. */
+			c.State = StateText
+		default:
+			c.State = StateTag
+		}
+	case StateTag:
+		switch {
+		case b == '>':
+			c.EndTag()
+		case IsSpace(b) || (b == '/'):
+		default:
+			c.State = StateAttrName
+			c.Attr = string(Lower(b))
+		}
+	case StateAttrName, StateAfterAttrName:
+		switch {
+		case b == '=':
+			c.State = StateBeforeValue
+		case b == '>':
+			c.EndTag()
+		case b == '/':
+			c.State = StateTag
+		case IsSpace(b):
+			c.State = StateAfterAttrName
+		case c.State == StateAfterAttrName:
+			c.State = StateAttrName
+			c.Attr = string(Lower(b))
+		default:
+			c.Attr += string(Lower(b))
+		}
+	case StateBeforeValue:
+		switch {
+		case (b == '"') || (b == '\''):
+			c.State = StateValue
+			c.Quote = b
+		case b == '>':
+			c.EndTag()
+		case IsSpace(b):
+		default:
+			c.State = StateValue
+			c.Quote = 0
+		}
+	case StateValue:
+		switch {
+		case (c.Quote != 0) && (b == c.Quote):
+			c.State = StateTag
+		case (c.Quote == 0) && IsSpace(b):
+			c.State = StateTag
+		case (c.Quote == 0) && (b == '>'):
+			c.EndTag()
+		}
+	case StateComment:
+		c.AppendTail(b)
+		if strings.HasSuffix(c.Tail, "-->") {
+			c.State = StateText
+		}
+	case StateRawText:
+		c.AppendTail(b)
+		if strings.HasSuffix(c.Tail, "</"+c.Tag) {
+			c.State = StateTag
+			c.Closing = true
+		}
+	}
+}
+
+func (c *Context) Feed(text string) {
+	for i := 0; i < len(text); i++ {
+		c.Step(text[i])
+	}
+}
+
+/* Same reports whether c and other are the same escaping context. Name of the last tag only matters until text after it begins, except for raw text elements. */
+func (c *Context) Same(other *Context) bool {
+	if c.State != other.State {
+		return false
+	}
+	switch c.State {
+	case StateText, StateComment:
+		return true
+	case StateRawText:
+		return c.Tag == other.Tag
+	}
+	return (c.Tag == other.Tag) && (c.Closing == other.Closing) && (c.Attr == other.Attr) && (c.Quote == other.Quote)
+}
+
+/* CanEscapeHTML reports whether value written at c is safe after HTML escaping. URLs, event handlers, styles and scripts need escapers of their own, which are not supported. */
+func (c *Context) CanEscapeHTML() bool {
+	switch c.State {
+	case StateText:
+		return true
+	case StateValue:
+		switch {
+		case c.Quote == 0:
+			return false
+		case (c.Attr == "href") || (c.Attr == "src") || (c.Attr == "action") || (c.Attr == "formaction") || (c.Attr == "style"):
+			return false
+		case strings.HasPrefix(c.Attr, "on"):
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func (c *Context) Describe() string {
+	switch c.State {
+	case StateValue:
+		if c.Quote == 0 {
+			return "unquoted value of attribute " + c.Attr
+		}
+		return "value of attribute " + c.Attr
+	case StateRawText:
+		return "<" + c.Tag + "> element"
+	}
+	return c.State.String()
+}
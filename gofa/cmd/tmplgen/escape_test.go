@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		escaped  bool
+		describe string
+	}{
+		{"Text", "<p>", true, "text"},
+		{"AfterClosingTag", "<p>a</p>", true, "text"},
+		{"QuotedValue", `<p title="`, true, "value of attribute title"},
+		{"SingleQuotedValue", `<p title='`, true, "value of attribute title"},
+		{"ValueWithSpaces", `<p class = "`, true, "value of attribute class"},
+		{"AfterValue", `<p title="a">`, true, "text"},
+		{"UnquotedValue", `<p title=`, false, "unquoted attribute value"},
+		{"UnquotedValueStarted", `<p title=a`, false, "unquoted value of attribute title"},
+		{"URL", `<a href="`, false, "value of attribute href"},
+		{"Source", `<img src='`, false, "value of attribute src"},
+		{"EventHandler", `<p onclick="`, false, "value of attribute onclick"},
+		{"Style", `<p style="`, false, "value of attribute style"},
+		{"UpperCaseAttribute", `<a HREF="`, false, "value of attribute href"},
+		{"TagName", "<p", false, "tag name"},
+		{"Tag", "<p ", false, "tag"},
+		{"AttributeName", "<p tit", false, "attribute name"},
+		{"Comment", "<!-- ", false, "comment"},
+		{"AfterComment", "<!-- <p title=\" -->", true, "text"},
+		{"Script", "<script>", false, "<script> element"},
+		{"ScriptWithTags", "<script>'<p>'", false, "<script> element"},
+		{"AfterScript", "<script>a</script>", true, "text"},
+		{"UpperCaseScript", "<SCRIPT>a</Script>", true, "text"},
+		{"Style", "<style>", false, "<style> element"},
+		{"Textarea", "<textarea>", false, "<textarea> element"},
+		{"StrayLessThan", "a < b", true, "text"},
+		{"SelfClosing", `<br/>`, true, "text"},
+		{"ValueWithGreaterThan", `<p title="a>b`, true, "value of attribute title"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var c Context
+			c.Feed(test.html)
+			if c.CanEscapeHTML() != test.escaped {
+				t.Errorf("Expected CanEscapeHTML to be %v in %s", test.escaped, c.Describe())
+			}
+			if c.Describe() != test.describe {
+				t.Errorf("Expected context %q, got %q", test.describe, c.Describe())
+			}
+		})
+	}
+}
+
+func TestContextSame(t *testing.T) {
+	tests := []struct {
+		name     string
+		first    string
+		second   string
+		expected bool
+	}{
+		{"TextAfterDifferentTags", "<p>", "<p>a</p>", true},
+		{"Comments", "<!--", "<p><!-- a", true},
+		{"SameValue", `<p title="`, `<p title="a`, true},
+		{"DifferentAttributes", `<p title="`, `<p class="`, false},
+		{"DifferentQuotes", `<p title="`, `<p title='`, false},
+		{"DifferentTags", "<p ", "<script ", false},
+		{"DifferentRawText", "<script>", "<style>", false},
+		{"DifferentStates", "<p>", `<p title="`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var first, second Context
+			first.Feed(test.first)
+			second.Feed(test.second)
+			if first.Same(&second) != test.expected {
+				t.Errorf("Expected Same to be %v for %s and %s", test.expected, first.Describe(), second.Describe())
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+/*
+tmplgen compiles template written in a subset of html/template into Go function that writes directly to *http.Response.
+
+Supported actions are {{.}}, field access like {{.A.B}}, {{range .A}}...{{end}} over slices and comments; '-' trim markers work as in text/template. Values are HTML-escaped and may appear only in text and in quoted attribute values, the rest of html/template escaping contexts is rejected at generation time. Types of fields are taken from struct declarations in the package directory.
+
+Usage:
+
+	go run ./cmd/tmplgen -in ../std/fortunes.tmpl -out fortunes_tmpl.go -func FortunesTemplate -type []Fortune
+*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* Scope is the value of dot: Go expression and its type as written in source. */
+type Scope struct {
+	Expr string
+	Type string
+}
+
+type Generator struct {
+	Name    string
+	Structs map[string]map[string]string
+
+	Buffer  *bytes.Buffer
+	Context Context
+	Depth   int
+}
+
+func LoadStructs(dir string, skip string) (map[string]map[string]string, error) {
+	structs := make(map[string]map[string]string)
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	for _, name := range names {
+		if (filepath.Base(name) == filepath.Base(skip)) || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		file, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			spec, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			st, ok := spec.Type.(*ast.StructType)
+			if !ok {
+				return false
+			}
+
+			fields := make(map[string]string)
+			for _, field := range st.Fields.List {
+				for _, ident := range field.Names {
+					fields[ident.Name] = types.ExprString(field.Type)
+				}
+			}
+			structs[spec.Name.Name] = fields
+			return false
+		})
+	}
+
+	return structs, nil
+}
+
+/* UsesIdent reports whether generated code refers to ident, so unused loop variables are not declared. Code is split into Go tokens, so ident is found neither inside longer identifiers nor in string literals with template text. */
+func UsesIdent(code string, ident string) bool {
+	var s scanner.Scanner
+
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", fset.Base(), len(code)), []byte(code), nil, 0)
+	for {
+		_, tok, lit := s.Scan()
+		switch {
+		case tok == token.EOF:
+			return false
+		case (tok == token.IDENT) && (lit == ident):
+			return true
+		}
+	}
+}
+
+func (g *Generator) Errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", g.Name, line, fmt.Sprintf(format, args...))
+}
+
+func (g *Generator) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(g.Buffer, format, args...)
+}
+
+func (g *Generator) Resolve(scope Scope, path []string, line int) (Scope, error) {
+	for _, name := range path {
+		typ := strings.TrimPrefix(scope.Type, "*")
+		fields, ok := g.Structs[typ]
+		if !ok {
+			return Scope{}, g.Errorf(line, "can't access field %s of non-struct type %s", name, scope.Type)
+		}
+		ftyp, ok := fields[name]
+		if !ok {
+			return Scope{}, g.Errorf(line, "type %s has no field %s", typ, name)
+		}
+		scope = Scope{Expr: scope.Expr + "." + name, Type: ftyp}
+	}
+	return scope, nil
+}
+
+func (g *Generator) WriteText(text string) {
+	if strings.ContainsAny(text, "`\r") {
+		g.Printf("w.WriteString(%s)\n", strconv.Quote(text))
+	} else {
+		g.Printf("w.WriteString(`%s`)\n", text)
+	}
+}
+
+func (g *Generator) WriteValue(scope Scope, line int) error {
+	switch scope.Type {
+	case "string":
+		g.Printf("w.WriteHTMLString(%s)\n", scope.Expr)
+	case "database.ID":
+		g.Printf("w.WriteID(%s)\n", scope.Expr)
+	case "int":
+		g.Printf("WriteJSONInt(w, %s)\n", scope.Expr)
+	case "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32":
+		g.Printf("WriteJSONInt(w, int(%s))\n", scope.Expr)
+	default:
+		return g.Errorf(line, "can't write value of type %s", scope.Type)
+	}
+	return nil
+}
+
+func (g *Generator) Generate(nodes []Node, scope Scope) error {
+	for i := 0; i < len(nodes); i++ {
+		node := &nodes[i]
+
+		switch node.Type {
+		case NodeText:
+			g.WriteText(node.Text)
+			g.Context.Feed(node.Text)
+		case NodeField:
+			if !g.Context.CanEscapeHTML() {
+				return g.Errorf(node.Line, "values are not supported in %s", g.Context.Describe())
+			}
+			value, err := g.Resolve(scope, node.Field, node.Line)
+			if err != nil {
+				return err
+			}
+			if err := g.WriteValue(value, node.Line); err != nil {
+				return err
+			}
+		case NodeRange:
+			slice, err := g.Resolve(scope, node.Field, node.Line)
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(slice.Type, "[]") {
+				return g.Errorf(node.Line, "can't range over value of type %s", slice.Type)
+			}
+
+			elem := Scope{Expr: fmt.Sprintf("v%d", g.Depth), Type: slice.Type[len("[]"):]}
+			index := fmt.Sprintf("i%d", g.Depth)
+			ref := "&"
+			if _, ok := g.Structs[elem.Type]; ok {
+				elem.Type = "*" + elem.Type
+			} else {
+				ref = ""
+			}
+
+			/* Body may run any number of times, so it must leave escaping context as it found it. */
+			start := g.Context
+			outer := g.Buffer
+			g.Buffer = new(bytes.Buffer)
+			g.Depth++
+			if err := g.Generate(node.Body, elem); err != nil {
+				return err
+			}
+			g.Depth--
+			body := g.Buffer
+			g.Buffer = outer
+			if !g.Context.Same(&start) {
+				return g.Errorf(node.Line, "range body ends in %s, but starts in %s", g.Context.Describe(), start.Describe())
+			}
+
+			g.Printf("for %s := 0; %s < len(%s); %s++ {\n", index, index, slice.Expr, index)
+			if UsesIdent(body.String(), elem.Expr) {
+				g.Printf("%s := %s%s[%s]\n", elem.Expr, ref, slice.Expr, index)
+			}
+			g.Buffer.Write(body.Bytes())
+			g.Printf("}\n")
+		}
+	}
+	return nil
+}
+
+/* GenerateFile compiles template name with contents data into formatted Go source of function funcName with argument of type typ. */
+func GenerateFile(name string, data string, structs map[string]map[string]string, pkg string, funcName string, typ string) ([]byte, error) {
+	nodes, err := ParseTemplate(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	g := Generator{Name: name, Structs: structs, Buffer: new(bytes.Buffer)}
+	g.Printf("// Code generated by tmplgen from %s; DO NOT EDIT.\n\n", filepath.ToSlash(name))
+	g.Printf("package %s\n\n", pkg)
+	g.Printf("import \"github.com/anton2920/gofa/net/http\"\n\n")
+	g.Printf("/* %s renders %s into w. */\n", funcName, filepath.Base(name))
+	g.Printf("func %s(w *http.Response, data %s) {\n", funcName, typ)
+	if err := g.Generate(nodes, Scope{Expr: "data", Type: typ}); err != nil {
+		return nil, err
+	}
+	g.Printf("}\n")
+
+	source, err := format.Source(g.Buffer.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %v\n%s", err, g.Buffer.Bytes())
+	}
+	return source, nil
+}
+
+func main() {
+	in := flag.String("in", "", "path to template file")
+	out := flag.String("out", "", "path to generated Go file")
+	funcName := flag.String("func", "", "name of generated function")
+	typ := flag.String("type", "", "type of template data, e.g. '[]Fortune'")
+	pkg := flag.String("package", "main", "package of generated file")
+	flag.Parse()
+
+	if (len(*in) == 0) || (len(*out) == 0) || (len(*funcName) == 0) || (len(*typ) == 0) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("Failed to read template: %v", err)
+	}
+	structs, err := LoadStructs(filepath.Dir(*out), *out)
+	if err != nil {
+		log.Fatalf("Failed to load struct declarations: %v", err)
+	}
+
+	source, err := GenerateFile(*in, string(data), structs, *pkg, *funcName, *typ)
+	if err != nil {
+		log.Fatalf("Failed to generate code: %v", err)
+	}
+	if err := os.WriteFile(*out, source, 0644); err != nil {
+		log.Fatalf("Failed to write generated code: %v", err)
+	}
+}
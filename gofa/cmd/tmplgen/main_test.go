@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUsesIdent(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected bool
+	}{
+		{"Used", "w.WriteHTMLString(v0.Message)\n", true},
+		{"Unused", "w.WriteString(`<p>`)\n", false},
+		{"LongerIdent", "w.WriteHTMLString(v01.Message)\n", false},
+		{"Field", "w.WriteHTMLString(x.v0)\n", true},
+		{"InRawString", "w.WriteString(`<p>v0</p>`)\n", false},
+		{"InString", "w.WriteString(\"v0\\n\")\n", false},
+		{"AfterDash", "w.WriteString(`data-v0`)\n", false},
+		{"AfterColon", "w.WriteString(`ns:v0`)\n", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if UsesIdent(test.code, "v0") != test.expected {
+				t.Errorf("Expected UsesIdent to return %v for %q", test.expected, test.code)
+			}
+		})
+	}
+}
+
+var TestStructs = map[string]map[string]string{
+	"Page": {"Title": "string", "Items": "[]Item", "Tags": "[]string"},
+	"Item": {"ID": "int", "Name": "string", "Count": "int32", "Flag": "bool"},
+}
+
+func TestGenerateFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		contains []string
+	}{
+		{"Text", "<p>`raw`</p>", []string{"w.WriteString(\"<p>`raw`</p>\")"}},
+		{"Value", "<h1>{{.Title}}</h1>", []string{"w.WriteHTMLString(data.Title)"}},
+		{"AttributeValue", `<p title="{{.Title}}">`, []string{"w.WriteHTMLString(data.Title)"}},
+		{"Range", "{{range .Items}}<p>{{.Name}} {{.ID}} {{.Count}}</p>{{end}}", []string{
+			"v0 := &data.Items[i0]",
+			"w.WriteHTMLString(v0.Name)",
+			"WriteJSONInt(w, v0.ID)",
+			"WriteJSONInt(w, int(v0.Count))",
+		}},
+		{"RangeOverStrings", "{{range .Tags}}<p>{{.}}</p>{{end}}", []string{"v0 := data.Tags[i0]", "w.WriteHTMLString(v0)"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := GenerateFile("test.tmpl", test.template, TestStructs, "main", "PageTemplate", "*Page")
+			if err != nil {
+				t.Fatalf("Failed to generate code: %v", err)
+			}
+			for _, code := range test.contains {
+				if !strings.Contains(string(source), code) {
+					t.Errorf("Expected generated code to contain %q, got\n%s", code, source)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateFileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"URL", `<a href="{{.Title}}">`, "values are not supported in value of attribute href"},
+		{"Unquoted", `<p title={{.Title}}>`, "values are not supported in unquoted attribute value"},
+		{"Script", `<script>var title = "{{.Title}}";</script>`, "values are not supported in <script> element"},
+		{"Comment", `<!-- {{.Title}} -->`, "values are not supported in comment"},
+		{"TagName", `<{{.Title}}>`, "values are not supported in tag name"},
+		{"RangeChangesContext", `{{range .Items}}<p title="{{end}}`, "range body ends in value of attribute title, but starts in text"},
+		{"UnknownField", "{{.Missing}}", "type Page has no field Missing"},
+		{"FieldOfString", "{{.Title.Length}}", "can't access field Length of non-struct type string"},
+		{"RangeOverString", "{{range .Title}}{{end}}", "can't range over value of type string"},
+		{"UnsupportedType", "{{range .Items}}{{.Flag}}{{end}}", "test.tmpl:1: can't write value of type bool"},
+		{"WholeStruct", "{{.}}", "can't write value of type *Page"},
+		{"RootInRange", "{{range .Items}}{{range $.Tags}}{{end}}{{end}}", "unsupported range expression"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := GenerateFile("test.tmpl", test.template, TestStructs, "main", "PageTemplate", "*Page")
+			if err == nil {
+				t.Fatalf("Expected error containing %q", test.expected)
+			}
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("Expected error containing %q, got %q", test.expected, err.Error())
+			}
+		})
+	}
+}
+
+/* TestGenerateFortunes checks that checked in fortunes_tmpl.go is up to date with ../std/fortunes.tmpl. */
+func TestGenerateFortunes(t *testing.T) {
+	data, err := os.ReadFile("../../../std/fortunes.tmpl")
+	if err != nil {
+		t.Fatalf("Failed to read template: %v", err)
+	}
+	expected, err := os.ReadFile("../../fortunes_tmpl.go")
+	if err != nil {
+		t.Fatalf("Failed to read generated code: %v", err)
+	}
+	structs, err := LoadStructs("../..", "../../fortunes_tmpl.go")
+	if err != nil {
+		t.Fatalf("Failed to load struct declarations: %v", err)
+	}
+
+	/* Name is the one go:generate directive in ../../main.go uses. */
+	source, err := GenerateFile("../std/fortunes.tmpl", string(data), structs, "main", "FortunesTemplate", "[]Fortune")
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+	if string(source) != string(expected) {
+		t.Errorf("fortunes_tmpl.go is out of date, run 'go generate'; expected\n%s\ngot\n%s", source, expected)
+	}
+}
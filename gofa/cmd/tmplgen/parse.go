@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type NodeType int
+
+const (
+	NodeText NodeType = iota
+	NodeField
+	NodeRange
+)
+
+/* Node is either piece of literal text, field access like {{.A.B}} or {{range .A}} block. Field path is empty for {{.}}. */
+type Node struct {
+	Type NodeType
+	Line int
+
+	Text  string
+	Field []string
+	Body  []Node
+}
+
+type Parser struct {
+	Name string
+	Data string
+	Pos  int
+	Line int
+
+	/* TrimNext is set by '-}}' and removes leading whitespace of the following text. */
+	TrimNext bool
+}
+
+func (p *Parser) Errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.Name, p.Line, fmt.Sprintf(format, args...))
+}
+
+func ParseTemplate(name string, data string) ([]Node, error) {
+	p := Parser{Name: name, Data: data, Line: 1}
+
+	nodes, end, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+	if end {
+		return nil, p.Errorf("unexpected {{end}}")
+	}
+	return nodes, nil
+}
+
+func ParseField(action string) ([]string, bool) {
+	if action == "." {
+		return nil, true
+	}
+	if (len(action) < 2) || (action[0] != '.') {
+		return nil, false
+	}
+
+	fields := strings.Split(action[1:], ".")
+	for i := 0; i < len(fields); i++ {
+		if !IsIdent(fields[i]) {
+			return nil, false
+		}
+	}
+	return fields, true
+}
+
+func IsIdent(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c != '_') && ((c < 'a') || (c > 'z')) && ((c < 'A') || (c > 'Z')) && ((i == 0) || (c < '0') || (c > '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+/* Parse parses nodes until the end of input or {{end}}, reporting which one it has met. */
+func (p *Parser) Parse() ([]Node, bool, error) {
+	var nodes []Node
+
+	for p.Pos < len(p.Data) {
+		open := strings.Index(p.Data[p.Pos:], "{{")
+		if open == -1 {
+			open = len(p.Data) - p.Pos
+		}
+		text := p.Data[p.Pos : p.Pos+open]
+		line := p.Line
+		p.Line += strings.Count(text, "\n")
+		p.Pos += open
+
+		if p.TrimNext {
+			text = strings.TrimLeft(text, " \t\r\n")
+			p.TrimNext = false
+		}
+		if strings.HasPrefix(p.Data[p.Pos:], "{{-") {
+			text = strings.TrimRight(text, " \t\r\n")
+		}
+		if len(text) > 0 {
+			nodes = append(nodes, Node{Type: NodeText, Line: line, Text: text})
+		}
+		if p.Pos == len(p.Data) {
+			break
+		}
+
+		close := strings.Index(p.Data[p.Pos:], "}}")
+		if close == -1 {
+			return nil, false, p.Errorf("unclosed action")
+		}
+		action := p.Data[p.Pos+len("{{") : p.Pos+close]
+		p.Line += strings.Count(action, "\n")
+		p.Pos += close + len("}}")
+
+		if strings.HasPrefix(action, "-") {
+			action = action[1:]
+		}
+		if strings.HasSuffix(action, "-") {
+			action = action[:len(action)-1]
+			p.TrimNext = true
+		}
+		action = strings.TrimSpace(action)
+
+		switch {
+		case strings.HasPrefix(action, "/*") && strings.HasSuffix(action, "*/"):
+		case action == "end":
+			return nodes, true, nil
+		case strings.HasPrefix(action, "range "):
+			field, ok := ParseField(strings.TrimSpace(action[len("range "):]))
+			if !ok {
+				return nil, false, p.Errorf("unsupported range expression %q", action)
+			}
+			line := p.Line
+			body, end, err := p.Parse()
+			if err != nil {
+				return nil, false, err
+			}
+			if !end {
+				return nil, false, p.Errorf("missing {{end}} for range on line %d", line)
+			}
+			nodes = append(nodes, Node{Type: NodeRange, Line: line, Field: field, Body: body})
+		default:
+			field, ok := ParseField(action)
+			if !ok {
+				return nil, false, p.Errorf("unsupported action %q", action)
+			}
+			nodes = append(nodes, Node{Type: NodeField, Line: p.Line, Field: field})
+		}
+	}
+
+	return nodes, false, nil
+}
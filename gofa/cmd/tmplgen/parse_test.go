@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected []Node
+	}{
+		{"Text", "<p>Hello</p>", []Node{{Type: NodeText, Line: 1, Text: "<p>Hello</p>"}}},
+		{"Dot", "<p>{{.}}</p>", []Node{
+			{Type: NodeText, Line: 1, Text: "<p>"},
+			{Type: NodeField, Line: 1},
+			{Type: NodeText, Line: 1, Text: "</p>"},
+		}},
+		{"Field", "{{ .A.B }}", []Node{{Type: NodeField, Line: 1, Field: []string{"A", "B"}}}},
+		{"Range", "<ul>\n{{range .Items}}<li>{{.Name}}</li>{{end}}\n</ul>", []Node{
+			{Type: NodeText, Line: 1, Text: "<ul>\n"},
+			{Type: NodeRange, Line: 2, Field: []string{"Items"}, Body: []Node{
+				{Type: NodeText, Line: 2, Text: "<li>"},
+				{Type: NodeField, Line: 2, Field: []string{"Name"}},
+				{Type: NodeText, Line: 2, Text: "</li>"},
+			}},
+			{Type: NodeText, Line: 2, Text: "\n</ul>"},
+		}},
+		{"Trim", "<p>\n\t{{- .A -}}\n\t</p>", []Node{
+			{Type: NodeText, Line: 1, Text: "<p>"},
+			{Type: NodeField, Line: 2, Field: []string{"A"}},
+			{Type: NodeText, Line: 2, Text: "</p>"},
+		}},
+		{"Comment", "a{{/* comment */}}b", []Node{
+			{Type: NodeText, Line: 1, Text: "a"},
+			{Type: NodeText, Line: 1, Text: "b"},
+		}},
+		{"Lines", "a\nb\n{{.A}}\n{{.B}}", []Node{
+			{Type: NodeText, Line: 1, Text: "a\nb\n"},
+			{Type: NodeField, Line: 3, Field: []string{"A"}},
+			{Type: NodeText, Line: 3, Text: "\n"},
+			{Type: NodeField, Line: 4, Field: []string{"B"}},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nodes, err := ParseTemplate("test.tmpl", test.template)
+			if err != nil {
+				t.Fatalf("Failed to parse template: %v", err)
+			}
+			if !reflect.DeepEqual(nodes, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, nodes)
+			}
+		})
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"Unclosed", "<p>{{.A</p>", "test.tmpl:1: unclosed action"},
+		{"UnexpectedEnd", "a\n{{end}}", "test.tmpl:2: unexpected {{end}}"},
+		{"MissingEnd", "{{range .A}}\n<p>", "missing {{end}} for range on line 1"},
+		{"Pipeline", "{{.A | html}}", `unsupported action ".A | html"`},
+		{"Function", "{{len .A}}", `unsupported action "len .A"`},
+		{"Variable", "{{range $i, $v := .A}}{{end}}", "unsupported range expression"},
+		{"BadField", "{{.A-B}}", `unsupported action ".A-B"`},
+		{"EmptyField", "{{.A..B}}", `unsupported action ".A..B"`},
+		{"If", "{{if .A}}{{end}}", `unsupported action "if .A"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseTemplate("test.tmpl", test.template)
+			if err == nil {
+				t.Fatalf("Expected error containing %q", test.expected)
+			}
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("Expected error containing %q, got %q", test.expected, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"strconv"
+	"strings"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+type Encoding int
+
+const (
+	EncodingIdentity Encoding = iota
+	EncodingGzip
+	EncodingDeflate
+)
+
+const CompressionLevel = flate.BestSpeed
+
+/* Compressors are created once per worker and reset for every response, so compression doesn't allocate after warm-up. Note that HTTP 'deflate' coding is zlib stream rather than raw DEFLATE. */
+type Compressors struct {
+	Gzip    *gzip.Writer
+	Deflate *zlib.Writer
+	Buffer  bytes.Buffer
+}
+
+/* ParseQValue returns weight of a single Accept-Encoding element and its coding name. Malformed weights are treated as 0, so such codings are never chosen. */
+func ParseQValue(element string) (string, float64) {
+	coding, params, _ := strings.Cut(element, ";")
+	coding = strings.ToLower(strings.TrimSpace(coding))
+
+	q := 1.0
+	for len(params) > 0 {
+		var param string
+
+		param, params, _ = strings.Cut(params, ";")
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+
+		var err error
+		q, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if (err != nil) || (q < 0) || (q > 1) {
+			q = 0
+		}
+	}
+	return coding, q
+}
+
+/* NegotiateEncoding picks coding with the highest weight from Accept-Encoding header, preferring gzip on ties. Missing header or header without supported codings means identity. */
+func NegotiateEncoding(header string) Encoding {
+	gzipQ, deflateQ, anyQ := -1.0, -1.0, -1.0
+	for len(header) > 0 {
+		var element string
+
+		element, header, _ = strings.Cut(header, ",")
+		coding, q := ParseQValue(element)
+		switch coding {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "deflate":
+			deflateQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	if deflateQ < 0 {
+		deflateQ = anyQ
+	}
+
+	switch {
+	case (gzipQ > 0) && (gzipQ >= deflateQ):
+		return EncodingGzip
+	case deflateQ > 0:
+		return EncodingDeflate
+	default:
+		return EncodingIdentity
+	}
+}
+
+func (c *Compressors) Compress(encoding Encoding, body []byte) ([]byte, error) {
+	var err error
+
+	c.Buffer.Reset()
+	switch encoding {
+	case EncodingGzip:
+		if c.Gzip == nil {
+			c.Gzip, err = gzip.NewWriterLevel(&c.Buffer, CompressionLevel)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			c.Gzip.Reset(&c.Buffer)
+		}
+		if _, err := c.Gzip.Write(body); err != nil {
+			return nil, err
+		}
+		if err := c.Gzip.Close(); err != nil {
+			return nil, err
+		}
+	case EncodingDeflate:
+		if c.Deflate == nil {
+			c.Deflate, err = zlib.NewWriterLevel(&c.Buffer, CompressionLevel)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			c.Deflate.Reset(&c.Buffer)
+		}
+		if _, err := c.Deflate.Write(body); err != nil {
+			return nil, err
+		}
+		if err := c.Deflate.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return c.Buffer.Bytes(), nil
+}
+
+/* CompressResponse compresses w's body in place if it is large enough and client accepts it. Partial responses and responses with validators are left as is, because their ranges and ETags refer to the original representation. Responses to HEAD still have their bodies here, so they are compressed as well and report the same Content-Encoding and Content-Length as GET. */
+func CompressResponse(worker *Worker, w *http.Response, r *http.Request) {
+	if !Config.Compress || (len(w.Body) < Config.CompressMinSize) {
+		return
+	}
+	if ((w.StatusCode != 0) && (w.StatusCode != http.StatusOK)) || w.Headers.Has("Content-Encoding") || w.Headers.Has("ETag") {
+		return
+	}
+	w.Headers.Set("Vary", "Accept-Encoding")
+
+	encoding := NegotiateEncoding(r.Headers.Get("Accept-Encoding"))
+	if encoding == EncodingIdentity {
+		return
+	}
+
+	compressed, err := worker.Compressors.Compress(encoding, w.Body)
+	if (err != nil) || (len(compressed) >= len(w.Body)) {
+		return
+	}
+	w.Body = append(w.Body[:0], compressed...)
+
+	switch encoding {
+	case EncodingGzip:
+		w.Headers.Set("Content-Encoding", "gzip")
+	case EncodingDeflate:
+		w.Headers.Set("Content-Encoding", "deflate")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+func TestParseQValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		element string
+		coding  string
+		q       float64
+	}{
+		{"NoWeight", "gzip", "gzip", 1},
+		{"Weight", " deflate;q=0.5 ", "deflate", 0.5},
+		{"Zero", "gzip;q=0", "gzip", 0},
+		{"Spaces", "GZIP ; Q = 0.3", "gzip", 0.3},
+		{"OtherParams", "gzip;level=1;q=0.7", "gzip", 0.7},
+		{"Malformed", "gzip;q=high", "gzip", 0},
+		{"TooLarge", "gzip;q=2", "gzip", 0},
+		{"Negative", "gzip;q=-1", "gzip", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			coding, q := ParseQValue(test.element)
+			if (coding != test.coding) || (q != test.q) {
+				t.Errorf("Expected %q with q=%g, got %q with q=%g", test.coding, test.q, coding, q)
+			}
+		})
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected Encoding
+	}{
+		{"Empty", "", EncodingIdentity},
+		{"Gzip", "gzip", EncodingGzip},
+		{"XGzip", "x-gzip", EncodingGzip},
+		{"Deflate", "deflate", EncodingDeflate},
+		{"PreferGzipOnTie", "deflate, gzip", EncodingGzip},
+		{"HigherWeight", "gzip;q=0.5, deflate;q=0.8", EncodingDeflate},
+		{"GzipRefused", "gzip;q=0, deflate", EncodingDeflate},
+		{"AllRefused", "gzip;q=0, deflate;q=0", EncodingIdentity},
+		{"IdentityRefused", "identity;q=0", EncodingIdentity},
+		{"IdentityRefusedWithGzip", "identity;q=0, gzip", EncodingGzip},
+		{"Any", "*", EncodingGzip},
+		{"AnyRefused", "*;q=0", EncodingIdentity},
+		{"AnyWithExplicit", "gzip;q=0, *", EncodingDeflate},
+		{"Unsupported", "br, zstd", EncodingIdentity},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if encoding := NegotiateEncoding(test.header); encoding != test.expected {
+				t.Errorf("Expected encoding %d, got %d", test.expected, encoding)
+			}
+		})
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	const minSize = 256
+
+	saved := Config
+	defer func() { Config = saved }()
+	Config.Compress = true
+	Config.CompressMinSize = minSize
+
+	large := strings.Repeat("Hello, compressed world! ", 32)
+
+	random := make([]byte, minSize)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name           string
+		body           string
+		status         http.Status
+		headers        []string
+		acceptEncoding string
+		encoding       string
+		vary           bool
+	}{
+		{"Gzip", large, http.StatusOK, nil, "gzip", "gzip", true},
+		{"Deflate", large, http.StatusOK, nil, "deflate", "deflate", true},
+		{"NotAccepted", large, http.StatusOK, nil, "", "", true},
+		{"Refused", large, http.StatusOK, nil, "gzip;q=0, deflate;q=0", "", true},
+		{"TooSmall", strings.Repeat("a", minSize-1), http.StatusOK, nil, "gzip", "", false},
+		{"MinSize", strings.Repeat("a", minSize), http.StatusOK, nil, "gzip", "gzip", true},
+		{"Incompressible", string(random), http.StatusOK, nil, "gzip", "", true},
+		{"NotOK", large, http.StatusNotFound, nil, "gzip", "", false},
+		{"PartialContent", large, http.StatusPartialContent, nil, "gzip", "", false},
+		{"WithETag", large, http.StatusOK, []string{"ETag", `"1"`}, "gzip", "", false},
+	}
+
+	var worker Worker
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var w http.Response
+			var r http.Request
+
+			w.StatusCode = test.status
+			for i := 0; i < len(test.headers); i += 2 {
+				w.Headers.Set(test.headers[i], test.headers[i+1])
+			}
+			w.WriteString(test.body)
+			if len(test.acceptEncoding) > 0 {
+				r.Headers.Set("Accept-Encoding", test.acceptEncoding)
+			}
+
+			CompressResponse(&worker, &w, &r)
+			if encoding := w.Headers.Get("Content-Encoding"); encoding != test.encoding {
+				t.Fatalf("Expected Content-Encoding %q, got %q", test.encoding, encoding)
+			}
+			if vary := w.Headers.Get("Vary") == "Accept-Encoding"; vary != test.vary {
+				t.Errorf("Expected Vary to be set: %v, got %v", test.vary, vary)
+			}
+
+			var body []byte
+			var err error
+			switch test.encoding {
+			case "gzip":
+				var reader *gzip.Reader
+				if reader, err = gzip.NewReader(bytes.NewReader(w.Body)); err == nil {
+					body, err = io.ReadAll(reader)
+				}
+			case "deflate":
+				var reader io.ReadCloser
+				if reader, err = zlib.NewReader(bytes.NewReader(w.Body)); err == nil {
+					body, err = io.ReadAll(reader)
+				}
+			default:
+				body = w.Body
+			}
+			if err != nil {
+				t.Fatalf("Failed to decompress body: %v", err)
+			}
+			if string(body) != test.body {
+				t.Errorf("Expected body %q, got %q", test.body, body)
+			}
+		})
+	}
+}
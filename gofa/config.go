@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/anton2920/gofa/log"
+)
+
+type Configuration struct {
+	Address string
+	Backlog int
+	Accept  string
+
+	Workers           int
+	ContextsPerWorker int
+	BufferSize        int
+	BatchSize         int
+	EventsSize        int
+	ShutdownTimeout   int
+
+	IdleTimeout   int
+	HeaderTimeout int
+	WriteTimeout  int
+
+	DBMode           string
+	FortunesDBPath   string
+	FortunesBlobPath string
+	WorldDBPath      string
+
+	MaxFortuneLength int
+	MaxBodySize      int
+
+	StaticDir    string
+	StaticPrefix string
+	Sendfile     bool
+
+	Compress        bool
+	CompressMinSize int
+
+	ImportPath string
+}
+
+var Config Configuration
+
+func GetEnvString(key string, defaultValue string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	return value
+}
+
+func GetEnvInt(key string, defaultValue int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Failed to parse %s=%q as integer: %v", key, value, err)
+	}
+	return n
+}
+
+func GetEnvBool(key string, defaultValue bool) bool {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Failed to parse %s=%q as boolean: %v", key, value, err)
+	}
+	return b
+}
+
+/* ParseConfig fills Config from environment variables and command-line flags, in that order of precedence from lowest to highest. */
+func ParseConfig() error {
+	flag.StringVar(&Config.Address, "address", GetEnvString("GOFA_ADDRESS", "0.0.0.0:7073"), "listen address [GOFA_ADDRESS]")
+	flag.IntVar(&Config.Backlog, "backlog", GetEnvInt("GOFA_BACKLOG", 128), "listen backlog [GOFA_BACKLOG]")
+	flag.StringVar(&Config.Accept, "accept", GetEnvString("GOFA_ACCEPT", AcceptSingle), "accept model: 'single' accepts in main goroutine, 'reuseport' gives every worker its own listener [GOFA_ACCEPT]")
+
+	flag.IntVar(&Config.Workers, "workers", GetEnvInt("GOFA_WORKERS", 0), "number of server workers, 0 means min(GOMAXPROCS/2, NumCPU) [GOFA_WORKERS]")
+	flag.IntVar(&Config.ContextsPerWorker, "contexts", GetEnvInt("GOFA_CONTEXTS", 512), "number of HTTP contexts per worker [GOFA_CONTEXTS]")
+	flag.IntVar(&Config.BufferSize, "buffer", GetEnvInt("GOFA_BUFFER", 1024), "per-connection buffer size in bytes [GOFA_BUFFER]")
+	flag.IntVar(&Config.BatchSize, "batch", GetEnvInt("GOFA_BATCH", 32), "number of requests parsed at once [GOFA_BATCH]")
+	flag.IntVar(&Config.EventsSize, "events", GetEnvInt("GOFA_EVENTS", 64), "number of events received at once [GOFA_EVENTS]")
+	flag.IntVar(&Config.ShutdownTimeout, "shutdown-timeout", GetEnvInt("GOFA_SHUTDOWN_TIMEOUT", 5), "seconds to flush pending responses on shutdown [GOFA_SHUTDOWN_TIMEOUT]")
+	flag.IntVar(&Config.IdleTimeout, "idle-timeout", GetEnvInt("GOFA_IDLE_TIMEOUT", 60), "seconds keep-alive connection may stay without requests, 0 disables [GOFA_IDLE_TIMEOUT]")
+	flag.IntVar(&Config.HeaderTimeout, "header-timeout", GetEnvInt("GOFA_HEADER_TIMEOUT", 10), "seconds client has to send complete request after its first byte, 0 disables [GOFA_HEADER_TIMEOUT]")
+	flag.IntVar(&Config.WriteTimeout, "write-timeout", GetEnvInt("GOFA_WRITE_TIMEOUT", 10), "seconds client has to accept pending response data, 0 disables [GOFA_WRITE_TIMEOUT]")
+
+	flag.StringVar(&Config.DBMode, "db-mode", GetEnvString("GOFA_DB_MODE", DBModeReset), "database startup mode: 'reset' drops and seeds data, 'persist' keeps existing data and seeds only empty databases [GOFA_DB_MODE]")
+	flag.StringVar(&Config.FortunesDBPath, "fortunes-db", GetEnvString("GOFA_FORTUNES_DB", "Fortunes.db"), "path to fortunes database file [GOFA_FORTUNES_DB]")
+	flag.StringVar(&Config.FortunesBlobPath, "fortunes-blob", GetEnvString("GOFA_FORTUNES_BLOB", "Fortunes.blob"), "path to file with long fortune messages [GOFA_FORTUNES_BLOB]")
+	flag.StringVar(&Config.WorldDBPath, "world-db", GetEnvString("GOFA_WORLD_DB", "World.db"), "path to world database file [GOFA_WORLD_DB]")
+	flag.IntVar(&Config.MaxFortuneLength, "max-fortune-length", GetEnvInt("GOFA_MAX_FORTUNE_LENGTH", 64*1024), "maximum length of fortune message in bytes [GOFA_MAX_FORTUNE_LENGTH]")
+	flag.IntVar(&Config.MaxBodySize, "max-body-size", GetEnvInt("GOFA_MAX_BODY_SIZE", 512), "maximum size of request body in bytes, must be smaller than per-connection buffer [GOFA_MAX_BODY_SIZE]")
+
+	flag.StringVar(&Config.StaticDir, "static-dir", GetEnvString("GOFA_STATIC_DIR", ""), "directory with static files, empty disables static file serving [GOFA_STATIC_DIR]")
+	flag.StringVar(&Config.StaticPrefix, "static-prefix", GetEnvString("GOFA_STATIC_PREFIX", "/static/"), "URL path prefix under which static files are served [GOFA_STATIC_PREFIX]")
+	flag.BoolVar(&Config.Sendfile, "sendfile", GetEnvBool("GOFA_SENDFILE", true), "send static files with sendfile(2) instead of copying them into response buffer [GOFA_SENDFILE]")
+
+	flag.BoolVar(&Config.Compress, "compress", GetEnvBool("GOFA_COMPRESS", false), "compress responses with gzip or deflate, if client accepts it [GOFA_COMPRESS]")
+	flag.IntVar(&Config.CompressMinSize, "compress-min-size", GetEnvInt("GOFA_COMPRESS_MIN_SIZE", 1024), "minimum size of response body in bytes to be compressed [GOFA_COMPRESS_MIN_SIZE]")
+
+	flag.StringVar(&Config.ImportPath, "import", "", "import fortunes from SQL file into fortunes database and exit; fortune IDs in file must be 1, 2, ..., N without gaps; requires -db-mode=persist, which server must be started with as well, since 'reset' mode drops imported fortunes")
+	flag.Parse()
+
+	if Config.Workers == 0 {
+		Config.Workers = max(min(runtime.GOMAXPROCS(0)/2, runtime.NumCPU()), 1)
+	}
+
+	return CheckConfig(&Config)
+}
+
+func CheckConfig(config *Configuration) error {
+	if len(config.Address) == 0 {
+		return errors.New("listen address must not be empty")
+	}
+	if config.Backlog <= 0 {
+		return fmt.Errorf("listen backlog must be positive, got %d", config.Backlog)
+	}
+	if (config.Accept != AcceptSingle) && (config.Accept != AcceptReusePort) {
+		return fmt.Errorf("accept model must be either %q or %q, got %q", AcceptSingle, AcceptReusePort, config.Accept)
+	}
+	if config.Workers <= 0 {
+		return fmt.Errorf("number of workers must be positive, got %d", config.Workers)
+	}
+	if config.ContextsPerWorker <= 0 {
+		return fmt.Errorf("number of contexts per worker must be positive, got %d", config.ContextsPerWorker)
+	}
+	if config.BufferSize <= 0 {
+		return fmt.Errorf("buffer size must be positive, got %d", config.BufferSize)
+	}
+	if config.BatchSize <= 0 {
+		return fmt.Errorf("batch size must be positive, got %d", config.BatchSize)
+	}
+	if config.EventsSize <= 0 {
+		return fmt.Errorf("events size must be positive, got %d", config.EventsSize)
+	}
+	if config.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown timeout must not be negative, got %d", config.ShutdownTimeout)
+	}
+	if (config.IdleTimeout < 0) || (config.HeaderTimeout < 0) || (config.WriteTimeout < 0) {
+		return fmt.Errorf("timeouts must not be negative, got idle=%d header=%d write=%d", config.IdleTimeout, config.HeaderTimeout, config.WriteTimeout)
+	}
+	if (config.DBMode != DBModeReset) && (config.DBMode != DBModePersist) {
+		return fmt.Errorf("database mode must be either %q or %q, got %q", DBModeReset, DBModePersist, config.DBMode)
+	}
+	if (len(config.ImportPath) > 0) && (config.DBMode != DBModePersist) {
+		return fmt.Errorf("import requires database mode %q, otherwise imported fortunes are dropped on the next start", DBModePersist)
+	}
+	if len(config.FortunesDBPath) == 0 {
+		return errors.New("fortunes database path must not be empty")
+	}
+	if len(config.FortunesBlobPath) == 0 {
+		return errors.New("fortunes blob path must not be empty")
+	}
+	if len(config.WorldDBPath) == 0 {
+		return errors.New("world database path must not be empty")
+	}
+	if config.MaxFortuneLength <= 0 {
+		return fmt.Errorf("maximum fortune length must be positive, got %d", config.MaxFortuneLength)
+	}
+	if (config.MaxBodySize < 0) || (config.MaxBodySize >= config.BufferSize) {
+		return fmt.Errorf("maximum body size must be in range [0; %d), got %d", config.BufferSize, config.MaxBodySize)
+	}
+	if config.CompressMinSize < 0 {
+		return fmt.Errorf("minimum compressed size must not be negative, got %d", config.CompressMinSize)
+	}
+	if len(config.StaticDir) > 0 {
+		info, err := os.Stat(config.StaticDir)
+		if err != nil {
+			return fmt.Errorf("failed to stat static directory: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("static directory %q is not a directory", config.StaticDir)
+		}
+		if (len(config.StaticPrefix) < 2) || (config.StaticPrefix[0] != '/') || (config.StaticPrefix[len(config.StaticPrefix)-1] != '/') {
+			return fmt.Errorf("static prefix must start and end with '/' and not be root, got %q", config.StaticPrefix)
+		}
+	}
+	return nil
+}
+
+func (config *Configuration) String() string {
+	return fmt.Sprintf("address=%s backlog=%d accept=%s workers=%d contexts=%d buffer=%d batch=%d events=%d shutdown-timeout=%d idle-timeout=%d header-timeout=%d write-timeout=%d db-mode=%s fortunes-db=%s fortunes-blob=%s world-db=%s max-fortune-length=%d max-body-size=%d static-dir=%s static-prefix=%s sendfile=%t compress=%t compress-min-size=%d",
+		config.Address, config.Backlog, config.Accept,
+		config.Workers, config.ContextsPerWorker, config.BufferSize, config.BatchSize, config.EventsSize, config.ShutdownTimeout, config.IdleTimeout, config.HeaderTimeout, config.WriteTimeout,
+		config.DBMode, config.FortunesDBPath, config.FortunesBlobPath, config.WorldDBPath, config.MaxFortuneLength, config.MaxBodySize,
+		config.StaticDir, config.StaticPrefix, config.Sendfile, config.Compress, config.CompressMinSize)
+}
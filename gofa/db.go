@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/log"
+)
+
+const (
+	DBModeReset   = "reset"
+	DBModePersist = "persist"
+)
+
+/* RecordKey returns database key of record with the given ID. IDs start from 1, like in tables other stacks serve, while database.IncrementNextID hands out keys starting from zero. */
+func RecordKey(id database.ID) database.ID {
+	return id - 1
+}
+
+/* CheckDB walks over every record stored in db and verifies that IDs are strictly increasing and that the next ID handed out by database.IncrementNextID follows the last stored one. It returns the number of stored records. */
+func CheckDB[T any](db *database.DB, getID func(*T) database.ID) (int, error) {
+	var records [64]T
+	var lastID database.ID
+	var pos int64
+	var count int
+
+	for {
+		n, err := database.ReadMany(db, &pos, records[:])
+		if err != nil {
+			return 0, fmt.Errorf("failed to read records: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+
+		for i := 0; i < n; i++ {
+			id := getID(&records[i])
+			if (count > 0) && (id <= lastID) {
+				return 0, fmt.Errorf("record %d has ID %d, which is not greater than previous ID %d", count, id, lastID)
+			}
+			lastID = id
+			count++
+		}
+	}
+
+	nextID, err := database.GetNextID(db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get next ID: %w", err)
+	}
+	if (count > 0) && (nextID != lastID+1) {
+		return 0, fmt.Errorf("next ID is %d, but last stored record has ID %d", nextID, lastID)
+	}
+
+	return count, nil
+}
+
+/* PrepareDB makes db ready for serving according to Config.DBMode. In reset mode db is dropped and seeded; in persist mode it is checked and seeded only if empty. */
+func PrepareDB[T any](db *database.DB, name string, getID func(*T) database.ID, drop func() error, seed func() error) error {
+	switch Config.DBMode {
+	case DBModeReset:
+		if err := drop(); err != nil {
+			return err
+		}
+	case DBModePersist:
+		n, err := CheckDB(db, getID)
+		if err != nil {
+			return fmt.Errorf("%s data is inconsistent: %w", name, err)
+		}
+		if n > 0 {
+			log.Infof("Using %d existing %s records", n, name)
+			return nil
+		}
+	}
+
+	if err := seed(); err != nil {
+		return err
+	}
+	log.Infof("Seeded %s data", name)
+	return nil
+}
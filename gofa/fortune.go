@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/anton2920/gofa/database"
+)
+
+type Fortune struct {
+	ID      database.ID
+	Message string
+
+	/* BlobOffset and BlobLength locate messages that don't fit into Data inside FortunesBlob. */
+	BlobOffset int64
+	BlobLength int64
+
+	Data [128]byte
+}
+
+/* FortuneTooLongError is returned by CreateFortune for messages longer than Config.MaxFortuneLength. */
+type FortuneTooLongError struct {
+	Length int
+	Max    int
+}
+
+/* FortunesPageSize is the minimal number of fortunes read by single call to database.ReadMany. */
+const FortunesPageSize = 16
+
+var (
+	FortunesDB *database.DB
+
+	/* FortunesBlob is append-only file holding messages of arbitrary length. FortunesBlobSize is where the next message goes; space is reserved under FortunesLock. */
+	FortunesBlob     *os.File
+	FortunesBlobSize atomic.Int64
+
+	/* FortunesLock makes blob reservation, ID allocation and record write atomic for fortunes created by different workers. */
+	FortunesLock sync.Mutex
+)
+
+func (e FortuneTooLongError) Error() string {
+	return fmt.Sprintf("fortune message is %d bytes long, which exceeds maximum of %d bytes", e.Length, e.Max)
+}
+
+func OpenFortunes(path string, blobPath string) error {
+	var err error
+
+	FortunesDB, err = database.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open fortunes DB file: %w", err)
+	}
+
+	FortunesBlob, err = os.OpenFile(blobPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		database.Close(FortunesDB)
+		return fmt.Errorf("failed to open fortunes blob file: %w", err)
+	}
+	info, err := FortunesBlob.Stat()
+	if err != nil {
+		CloseFortunes()
+		return fmt.Errorf("failed to stat fortunes blob file: %w", err)
+	}
+	FortunesBlobSize.Store(info.Size())
+
+	return nil
+}
+
+func CloseFortunes() {
+	FortunesBlob.Close()
+	database.Close(FortunesDB)
+}
+
+func DropFortunes() error {
+	if err := database.Drop(FortunesDB); err != nil {
+		return fmt.Errorf("failed to drop fortunes data: %w", err)
+	}
+	if err := FortunesBlob.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate fortunes blob: %w", err)
+	}
+	FortunesBlobSize.Store(0)
+	return nil
+}
+
+/* CreateFortune stores fortune under the next free ID. Blob space is reserved under FortunesLock, so reservation is always the last one and is given back if anything fails. */
+func CreateFortune(fortune *Fortune) error {
+	var fortuneDB Fortune
+
+	if len(fortune.Message) > Config.MaxFortuneLength {
+		return FortuneTooLongError{Length: len(fortune.Message), Max: Config.MaxFortuneLength}
+	}
+
+	FortunesLock.Lock()
+	defer FortunesLock.Unlock()
+
+	if len(fortune.Message) > len(fortuneDB.Data) {
+		fortuneDB.BlobLength = int64(len(fortune.Message))
+		fortuneDB.BlobOffset = FortunesBlobSize.Add(fortuneDB.BlobLength) - fortuneDB.BlobLength
+	} else {
+		data := unsafe.Slice(&fortuneDB.Data[0], len(fortuneDB.Data))
+		database.String2DBString(&fortuneDB.Message, fortune.Message, data, 0)
+	}
+
+	err := WriteFortune(fortune, &fortuneDB)
+	if (err != nil) && (fortuneDB.BlobLength > 0) {
+		FortunesBlobSize.Store(fortuneDB.BlobOffset)
+		_ = FortunesBlob.Truncate(fortuneDB.BlobOffset)
+	}
+	return err
+}
+
+/* WriteFortune writes message of fortuneDB into its reserved blob space, if it has one, and record itself under the next free ID. */
+func WriteFortune(fortune *Fortune, fortuneDB *Fortune) error {
+	if fortuneDB.BlobLength > 0 {
+		if _, err := FortunesBlob.WriteAt(unsafe.Slice(unsafe.StringData(fortune.Message), len(fortune.Message)), fortuneDB.BlobOffset); err != nil {
+			return fmt.Errorf("failed to write fortune message to blob: %w", err)
+		}
+	}
+
+	key, err := database.IncrementNextID(FortunesDB)
+	if err != nil {
+		return fmt.Errorf("failed to increment fortune ID: %w", err)
+	}
+	fortune.ID = key + 1
+	fortuneDB.ID = fortune.ID
+
+	return database.Write(FortunesDB, key, fortuneDB)
+}
+
+/* GetFortunes reads next page of fortunes starting from *pos. Long messages are read into *messages, which is grown as needed; returned strings point into it. */
+func GetFortunes(pos *int64, fortunes []Fortune, messages *[]byte) (int, error) {
+	n, err := database.ReadMany(FortunesDB, pos, fortunes)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < n; i++ {
+		fortune := &fortunes[i]
+		if fortune.BlobLength > 0 {
+			start := len(*messages)
+			*messages = slices.Grow(*messages, int(fortune.BlobLength))[:start+int(fortune.BlobLength)]
+			message := (*messages)[start:]
+			if _, err := FortunesBlob.ReadAt(message, fortune.BlobOffset); err != nil {
+				return 0, fmt.Errorf("failed to read message of fortune %d from blob: %w", fortune.ID, err)
+			}
+			fortune.Message = unsafe.String(&message[0], len(message))
+		} else {
+			fortune.Message = database.Offset2String(fortune.Message, &fortune.Data[0])
+		}
+	}
+	return n, nil
+}
+
+/* GetAllFortunes reads every stored fortune page by page, reusing fortunes and messages as scratch space and growing them when table doesn't fit. */
+func GetAllFortunes(fortunes []Fortune, messages []byte) ([]Fortune, []byte, error) {
+	var pos int64
+
+	fortunes = fortunes[:0]
+	messages = messages[:0]
+	for {
+		if len(fortunes) == cap(fortunes) {
+			fortunes = slices.Grow(fortunes, max(cap(fortunes), FortunesPageSize))
+		}
+
+		n, err := GetFortunes(&pos, fortunes[len(fortunes):cap(fortunes)], &messages)
+		if err != nil {
+			return fortunes, messages, err
+		}
+		if n == 0 {
+			break
+		}
+		fortunes = fortunes[:len(fortunes)+n]
+	}
+
+	return fortunes, messages, nil
+}
+
+func CreateFortunes() error {
+	fortunes := [...]Fortune{
+		{Message: `fortune: No such file or directory`},
+		{Message: `A computer scientist is someone who fixes things that aren't broken.`},
+		{Message: `After enough decimal places, nobody gives a damn.`},
+		{Message: `A bad random number generator: 1, 1, 1, 1, 1, 4.33e+67, 1, 1, 1`},
+		{Message: `A computer program does what you tell it to do, not what you want it to do.`},
+		{Message: `Emacs is a nice operating system, but I prefer UNIX. — Tom Christaensen`},
+		{Message: `Any program that runs right is obsolete.`},
+		{Message: `A list is only as strong as its weakest link. — Donald Knuth`},
+		{Message: `Feature: A bug with seniority.`},
+		{Message: `Computers make very fast, very accurate mistakes.`},
+		{Message: `<script>alert("This should not be displayed in a browser alert box.");</script>`},
+		{Message: `フレームワークのベンチマーク`},
+	}
+
+	for i := 0; i < len(fortunes); i++ {
+		if err := CreateFortune(&fortunes[i]); err != nil {
+			return fmt.Errorf("failed to create fortune %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
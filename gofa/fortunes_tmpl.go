@@ -0,0 +1,31 @@
+// Code generated by tmplgen from ../std/fortunes.tmpl; DO NOT EDIT.
+
+package main
+
+import "github.com/anton2920/gofa/net/http"
+
+/* FortunesTemplate renders fortunes.tmpl into w. */
+func FortunesTemplate(w *http.Response, data []Fortune) {
+	w.WriteString(`<!DOCTYPE html>
+<html>
+<head><title>Fortunes</title></head>
+<body>
+<table>
+<tr><th>id</th><th>message</th></tr>
+`)
+	for i0 := 0; i0 < len(data); i0++ {
+		v0 := &data[i0]
+		w.WriteString(`
+<tr><td>`)
+		w.WriteID(v0.ID)
+		w.WriteString(`</td><td>`)
+		w.WriteHTMLString(v0.Message)
+		w.WriteString(`</td></tr>
+`)
+	}
+	w.WriteString(`
+</table>
+</body>
+</html>
+`)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	stdhttp "net/http"
+	"strconv"
+	"strings"
+	stdsyscall "syscall"
+	"testing"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/alloc"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/net/tcp"
+)
+
+/* FuzzCorpus contains pipelined, truncated and oversized requests. Requests with side effects, like creating fortunes or updating worlds, are left to the fuzzer to discover; POST /fortunes below is rejected before anything is written. */
+var FuzzCorpus = [...]string{
+	Get("/plaintext"),
+	Get("/plaintext") + Get("/json") + Get("/db") + Get("/queries?queries=3") + Get("/cached-queries?count=2") + Get("/fortunes"),
+	Get("/static/large.bin") + Get("/static/hello.txt"),
+	Get("/plaintext") + "GET /static/hello.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=2-5\r\n\r\n",
+	"HEAD /json HTTP/1.1\r\nHost: localhost\r\n\r\n" + "OPTIONS /plaintext HTTP/1.1\r\nHost: localhost\r\n\r\n" + Get("/unknown"),
+	"GET /plaintext HTTP/1.0\r\n\r\n" + "GET /plaintext HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n" + Get("/json"),
+	Get("/queries?queries=abc") + Get("/queries?queries=-1") + Get("/queries?queries=100000") + Get("/queries"),
+
+	"GET /plaintext HTTP/1.1\r\nHost: local",
+	"GET /pla",
+	"GET /plaintext HTTP/1.1\r\n",
+	Get("/json") + "GET /json HTTP/1.1\r\nHo",
+
+	"GET /plaintext HTTP/1.1\r\nHost: localhost\r\nX-Padding: " + strings.Repeat("a", 4096) + "\r\n\r\n",
+	"GET /" + strings.Repeat("x", 4096) + " HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	"GET /plaintext HTTP/1.1\r\n" + strings.Repeat("X-Header: value\r\n", 256) + "\r\n",
+	strings.Repeat(Get("/plaintext"), 256),
+
+	"POST /plaintext HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" + Get("/plaintext"),
+	"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+	"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 100000\r\n\r\n",
+	"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+
+	"\x00\x01\x02\r\n\r\n",
+	"get /plaintext http/1.1\r\n\r\n",
+	"GET  /plaintext  HTTP/1.1\r\n\r\n",
+	"GET /plaintext HTTP/9.9\r\n\r\n",
+	"\r\n\r\n\r\n" + Get("/plaintext"),
+}
+
+/* FuzzPipeline feeds arbitrary byte stream, split into reads of sizes taken from splits, to the same code ServerWorker uses. Server must neither panic nor produce anything but well-formed HTTP/1.1 responses. */
+func FuzzPipeline(f *testing.F) {
+	for _, seed := range FuzzCorpus {
+		f.Add([]byte(seed), []byte(nil))
+		f.Add([]byte(seed), []byte{0})
+		f.Add([]byte(seed), []byte{6, 1, 30})
+	}
+
+	l, err := tcp.Listen("127.0.0.1:0", 16)
+	if err != nil {
+		f.Fatalf("Failed to listen: %v", err)
+	}
+	f.Cleanup(func() { CloseListener(l) })
+	port, err := ListenerPort(l)
+	if err != nil {
+		f.Fatalf("Failed to get listener port: %v", err)
+	}
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	pool := alloc.NewSyncPool[http.Context](4)
+	worker, err := NewWorker(&pool)
+	if err != nil {
+		f.Fatalf("Failed to create worker: %v", err)
+	}
+	f.Cleanup(func() { worker.Queue.Close() })
+
+	ws := make([]http.Response, Config.BatchSize)
+	rs := make([]http.Request, Config.BatchSize)
+
+	f.Fuzz(func(t *testing.T, data []byte, splits []byte) {
+		client, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Close()
+		client.SetDeadline(stdtime.Now().Add(5 * stdtime.Second))
+
+		ctx, err := http.Accept(l, &pool, Config.BufferSize)
+		if err != nil {
+			t.Fatalf("Failed to accept connection: %v", err)
+		}
+		if err := worker.Add(ctx); err != nil {
+			t.Fatalf("Failed to add connection to worker: %v", err)
+		}
+		conn := worker.Connection(ctx)
+
+		checked := make(chan error, 1)
+		go func() {
+			checked <- CheckResponseStream(client)
+		}()
+
+		/* Nothing sent after request that closes connection is read by server. */
+		for i := 0; (len(data) > 0) && (!conn.Closing); i++ {
+			n := len(data)
+			if len(splits) > 0 {
+				n = min(int(splits[i%len(splits)])+1, n)
+			}
+			if _, err := client.Write(data[:n]); err != nil {
+				break
+			}
+			data = data[n:]
+
+			if _, err := worker.ReadRequests(ctx, conn, ws, rs, GetDateHeader(), n); err != nil {
+				break
+			}
+			if err := FlushConnection(worker, ctx, conn, ws, rs); err != nil {
+				break
+			}
+		}
+		FlushConnection(worker, ctx, conn, ws, rs)
+		worker.Close(ctx)
+
+		if err := <-checked; err != nil {
+			t.Fatalf("Malformed response stream: %v", err)
+		}
+	})
+}
+
+/* FlushConnection writes everything conn has to send, including static file transfers and responses to requests that wait for them, the way ServerWorker does on write events. */
+func FlushConnection(worker *Worker, ctx *http.Context, conn *Connection, ws []http.Response, rs []http.Request) error {
+	for conn.Transferring {
+		if _, err := worker.PumpTransfer(ctx, conn); err != nil {
+			return err
+		}
+		if !conn.Transferring {
+			worker.ProcessRequests(ctx, conn, ws, rs, GetDateHeader())
+		}
+	}
+	return DrainContext(ctx)
+}
+
+/* DrainContext writes everything ctx has buffered for client. */
+func DrainContext(ctx *http.Context) error {
+	for {
+		n, err := http.Write(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+/* CheckResponseStream parses responses until server closes connection. */
+func CheckResponseStream(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	for {
+		if _, err := r.Peek(1); err != nil {
+			if (err == io.EOF) || errors.Is(err, stdsyscall.ECONNRESET) {
+				return nil
+			}
+			return err
+		}
+
+		resp, err := stdhttp.ReadResponse(r, nil)
+		if err != nil {
+			return err
+		}
+		if (resp.StatusCode < 100) || (resp.StatusCode > 599) {
+			return fmt.Errorf("invalid status code %d", resp.StatusCode)
+		}
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read body: %w", err)
+		}
+	}
+}
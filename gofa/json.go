@@ -0,0 +1,117 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+const hexDigits = "0123456789abcdef"
+
+/* WriteJSONString writes s as a quoted JSON string. Runs of bytes that need no escaping are written as substrings of s, so nothing is allocated. */
+func WriteJSONString(w *http.Response, s string) {
+	w.WriteString(`"`)
+
+	var start int
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if (c >= 0x20) && (c != '"') && (c != '\\') {
+				i++
+				continue
+			}
+
+			if start < i {
+				w.WriteString(s[start:i])
+			}
+			switch c {
+			case '"':
+				w.WriteString(`\"`)
+			case '\\':
+				w.WriteString(`\\`)
+			case '\b':
+				w.WriteString(`\b`)
+			case '\f':
+				w.WriteString(`\f`)
+			case '\n':
+				w.WriteString(`\n`)
+			case '\r':
+				w.WriteString(`\r`)
+			case '\t':
+				w.WriteString(`\t`)
+			default:
+				w.WriteString(`\u00`)
+				w.WriteString(hexDigits[c>>4 : c>>4+1])
+				w.WriteString(hexDigits[c&0xF : c&0xF+1])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError) && (size == 1) {
+			if start < i {
+				w.WriteString(s[start:i])
+			}
+			w.WriteString(`\ufffd`)
+			i += size
+			start = i
+			continue
+		}
+
+		/* U+2028 and U+2029 are valid JSON, but break JavaScript parsers that treat them as line terminators. */
+		if (r == '\u2028') || (r == '\u2029') {
+			if start < i {
+				w.WriteString(s[start:i])
+			}
+			w.WriteString(`\u202`)
+			w.WriteString(hexDigits[r&0xF : r&0xF+1])
+			i += size
+			start = i
+			continue
+		}
+
+		i += size
+	}
+	if start < len(s) {
+		w.WriteString(s[start:])
+	}
+
+	w.WriteString(`"`)
+}
+
+func JSONHandler(worker *Worker, w *http.Response, r *http.Request) error {
+	w.Headers.Set("Content-Type", "application/json")
+
+	w.WriteString(`{"message":`)
+	WriteJSONString(w, "Hello, World!")
+	w.WriteString(`}`)
+	return nil
+}
+
+/* WriteJSONInt writes n in decimal. Digits are produced from unsigned magnitude, since -n overflows for math.MinInt. */
+func WriteJSONInt(w *http.Response, n int) {
+	var buffer [20]byte
+
+	i := len(buffer)
+	neg := n < 0
+	u := uint(n)
+	if neg {
+		u = -u
+	}
+	for {
+		i--
+		buffer[i] = byte('0' + u%10)
+		u /= 10
+		if u == 0 {
+			break
+		}
+	}
+	if neg {
+		i--
+		buffer[i] = '-'
+	}
+
+	w.Write(buffer[i:])
+}
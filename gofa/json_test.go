@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+func TestWriteJSONString(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Empty", "", `""`},
+		{"Plain", "Hello, World!", `"Hello, World!"`},
+		{"QuoteAndBackslash", `say "hi" \ bye`, `"say \"hi\" \\ bye"`},
+		{"ShortEscapes", "\b\f\n\r\t", `"\b\f\n\r\t"`},
+		{"ControlCharacters", "a\x00b\x01c\x1fd\x7f", `"a\u0000b\u0001c\u001fd` + "\x7f" + `"`},
+		{"MultiByte", "フレームワーク", `"フレームワーク"`},
+		{"InvalidByte", "a\xffb", `"a\ufffdb"`},
+		{"TruncatedSequence", "a\xe2\x82", `"a\ufffd\ufffd"`},
+		{"InvalidAtStart", "\x80abc", `"\ufffdabc"`},
+		{"LineSeparator", "a\u2028b", `"a\u2028b"`},
+		{"ParagraphSeparator", "a\u2029b", `"a\u2029b"`},
+		{"OtherU202x", "a\u2027b", "\"a\u2027b\""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var w http.Response
+
+			WriteJSONString(&w, test.input)
+			if string(w.Body) != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, w.Body)
+			}
+		})
+	}
+}
+
+func TestWriteJSONInt(t *testing.T) {
+	tests := []int{0, 1, -1, 9, 10, -10, 1234567890, -1234567890, math.MaxInt, math.MinInt, math.MinInt + 1}
+
+	for _, n := range tests {
+		var w http.Response
+
+		WriteJSONInt(&w, n)
+		if expected := strconv.Itoa(n); string(w.Body) != expected {
+			t.Errorf("Expected %s, got %s", expected, w.Body)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	stdsyscall "syscall"
+
+	"github.com/anton2920/gofa/alloc"
+	"github.com/anton2920/gofa/log"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/net/http/http1"
+	"github.com/anton2920/gofa/syscall"
+)
+
+const (
+	AcceptSingle    = "single"
+	AcceptReusePort = "reuseport"
+)
+
+/* ParseSockaddr converts address with literal IPv4 or IPv6 host into socket address. Empty host means every IPv4 address. */
+func ParseSockaddr(address string) (int, stdsyscall.Sockaddr, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to split address %q: %w", address, err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to parse port %q: %w", port, err)
+	}
+	if len(host) == 0 {
+		return stdsyscall.AF_INET, &stdsyscall.SockaddrInet4{Port: p}, nil
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return 0, nil, fmt.Errorf("only IP addresses are supported, got %q", host)
+	}
+	if ip.Is4() || ip.Is4In6() {
+		return stdsyscall.AF_INET, &stdsyscall.SockaddrInet4{Port: p, Addr: ip.Unmap().As4()}, nil
+	}
+
+	sa := &stdsyscall.SockaddrInet6{Port: p, Addr: ip.As16()}
+	if zone := ip.Zone(); len(zone) > 0 {
+		ifi, err := net.InterfaceByName(zone)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to find interface for zone %q: %w", zone, err)
+		}
+		sa.ZoneId = uint32(ifi.Index)
+	}
+	return stdsyscall.AF_INET6, sa, nil
+}
+
+/* ListenReusePort opens non-blocking TCP listener with SO_REUSEPORT set, so every worker can bind to the same address and kernel balances connections between them. */
+func ListenReusePort(address string, backlog int) (int32, error) {
+	family, sa, err := ParseSockaddr(address)
+	if err != nil {
+		return -1, err
+	}
+
+	fd, err := stdsyscall.Socket(family, stdsyscall.SOCK_STREAM, stdsyscall.IPPROTO_TCP)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create socket: %w", err)
+	}
+	if err := stdsyscall.SetsockoptInt(fd, stdsyscall.SOL_SOCKET, stdsyscall.SO_REUSEADDR, 1); err != nil {
+		stdsyscall.Close(fd)
+		return -1, fmt.Errorf("failed to set SO_REUSEADDR: %w", err)
+	}
+	if err := stdsyscall.SetsockoptInt(fd, stdsyscall.SOL_SOCKET, SO_REUSEPORT, 1); err != nil {
+		stdsyscall.Close(fd)
+		return -1, fmt.Errorf("failed to set SO_REUSEPORT: %w", err)
+	}
+	if err := stdsyscall.SetNonblock(fd, true); err != nil {
+		stdsyscall.Close(fd)
+		return -1, fmt.Errorf("failed to set non-blocking mode: %w", err)
+	}
+	if err := stdsyscall.Bind(fd, sa); err != nil {
+		stdsyscall.Close(fd)
+		return -1, fmt.Errorf("failed to bind to %s: %w", address, err)
+	}
+	if err := stdsyscall.Listen(fd, backlog); err != nil {
+		stdsyscall.Close(fd)
+		return -1, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+
+	return int32(fd), nil
+}
+
+/* ListenWorkers gives every worker its own SO_REUSEPORT listener on address. Worker 0 binds first, and the rest reuse port it got, since with port 0 each socket would get different ephemeral port otherwise. */
+func ListenWorkers(workers []*Worker, address string, backlog int) error {
+	for i := 0; i < len(workers); i++ {
+		if err := workers[i].Listen(address, backlog); err != nil {
+			return fmt.Errorf("failed to listen on port for worker %d: %w", i, err)
+		}
+		if i == 0 {
+			port, err := ListenerPort(workers[0].Listener)
+			if err != nil {
+				return fmt.Errorf("failed to get listener port: %w", err)
+			}
+			host, _, _ := net.SplitHostPort(address)
+			address = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+	}
+	return nil
+}
+
+/* AcceptConnection accepts new connection from l and hands it to worker. */
+func AcceptConnection(l int32, pool *alloc.SyncPool[http.Context], worker *Worker) {
+	ctx, err := http.Accept(l, pool, Config.BufferSize)
+	if err != nil {
+		if err == http.TooManyClients {
+			worker.Metrics.RejectedAccepts.Add(1)
+			http1.FillError(ctx, err, GetDateHeader())
+			http.Write(ctx)
+			http.Close(ctx)
+		}
+		log.Errorf("Failed to accept new HTTP connection: %v", err)
+		return
+	}
+	if err := worker.Add(ctx); err != nil {
+		log.Errorf("Failed to add HTTP connection to client queue: %v", err)
+	}
+}
+
+func ListenerPort(l int32) (int, error) {
+	sa, err := stdsyscall.Getsockname(int(l))
+	if err != nil {
+		return 0, err
+	}
+	switch sa := sa.(type) {
+	case *stdsyscall.SockaddrInet4:
+		return sa.Port, nil
+	case *stdsyscall.SockaddrInet6:
+		return sa.Port, nil
+	default:
+		return 0, fmt.Errorf("unexpected socket address %T", sa)
+	}
+}
+
+func CloseListener(l int32) {
+	if err := syscall.Close(l); err != nil {
+		log.Errorf("Failed to close listener: %v", err)
+	}
+}
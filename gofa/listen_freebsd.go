@@ -0,0 +1,4 @@
+package main
+
+/* SO_REUSEPORT_LB is used on FreeBSD, since plain SO_REUSEPORT doesn't balance connections between sockets. */
+const SO_REUSEPORT = 0x10000
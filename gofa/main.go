@@ -1,154 +1,114 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"runtime"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/anton2920/gofa/alloc"
 	"github.com/anton2920/gofa/database"
 	"github.com/anton2920/gofa/event"
 	"github.com/anton2920/gofa/log"
-	"github.com/anton2920/gofa/net/html"
 	"github.com/anton2920/gofa/net/http"
-	"github.com/anton2920/gofa/net/http/http1"
 	"github.com/anton2920/gofa/net/tcp"
 	"github.com/anton2920/gofa/syscall"
 	"github.com/anton2920/gofa/time"
 )
 
-type Fortune struct {
-	ID      database.ID
-	Message string
-
-	Data [128]byte
-}
+//go:generate go run ./cmd/tmplgen -in ../std/fortunes.tmpl -out fortunes_tmpl.go -func FortunesTemplate -type []Fortune
 
 const PageSize = 4096
 
 var DateBufferPtr unsafe.Pointer
 
-var FortunesDB *database.DB
-
-func CreateFortune(fortune *Fortune) error {
-	var fortuneDB Fortune
-	var err error
+/* CreateFortuneHandler accepts message either as urlencoded form field 'message' or as plain text body. */
+func CreateFortuneHandler(worker *Worker, w *http.Response, r *http.Request) error {
+	var fortune Fortune
 
-	data := unsafe.Slice(&fortuneDB.Data[0], len(fortuneDB.Data))
-
-	fortune.ID, err = database.IncrementNextID(FortunesDB)
+	body, err := ReadBody(r)
 	if err != nil {
-		return fmt.Errorf("failed to increment fortune ID: %w", err)
+		return err
 	}
 
-	fortuneDB.ID = fortune.ID
-	database.String2DBString(&fortuneDB.Message, fortune.Message, data, 0)
-
-	return database.Write(FortunesDB, fortuneDB.ID, &fortuneDB)
-}
+	contentType := r.Headers.Get("Content-Type")
+	if semicolon := strings.IndexByte(contentType, ';'); semicolon != -1 {
+		contentType = contentType[:semicolon]
+	}
+	switch strings.TrimSpace(contentType) {
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return http.Error{StatusCode: http.StatusBadRequest, DisplayMessage: "malformed form data"}
+		}
+		fortune.Message = values.Get("message")
+	case "text/plain":
+		fortune.Message = string(body)
+	default:
+		return http.Error{StatusCode: http.StatusBadRequest, DisplayMessage: "unsupported Content-Type"}
+	}
 
-func GetFortunes(pos *int64, fortunes []Fortune) (int, error) {
-	n, err := database.ReadMany(FortunesDB, pos, fortunes)
-	if err != nil {
-		return 0, err
+	if len(fortune.Message) == 0 {
+		return http.Error{StatusCode: http.StatusBadRequest, DisplayMessage: "fortune message must not be empty"}
+	}
+	if !utf8.ValidString(fortune.Message) {
+		return http.Error{StatusCode: http.StatusBadRequest, DisplayMessage: "fortune message must be valid UTF-8"}
 	}
 
-	for i := 0; i < n; i++ {
-		fortune := &fortunes[i]
-		fortune.Message = database.Offset2String(fortune.Message, &fortune.Data[0])
+	if err := CreateFortune(&fortune); err != nil {
+		var tooLong FortuneTooLongError
+		if errors.As(err, &tooLong) {
+			return http.Error{StatusCode: http.StatusRequestEntityTooLarge, DisplayMessage: tooLong.Error()}
+		}
+		return http.ServerError(err)
 	}
-	return n, nil
+
+	w.StatusCode = http.StatusCreated
+	w.Headers.Set("Content-Type", "application/json")
+	w.WriteString(`{"id":`)
+	WriteJSONInt(w, int(fortune.ID))
+	w.WriteString(`,"message":`)
+	WriteJSONString(w, fortune.Message)
+	w.WriteString(`}`)
+	return nil
+}
+
+func PlaintextHandler(worker *Worker, w *http.Response, r *http.Request) error {
+	w.WriteString("Hello, world!\n")
+	return nil
 }
 
-func FortunesHandler(w *http.Response, r *http.Request) error {
-	fortunes := make([]Fortune, 12, 13)
-	var pos int64
+func FortunesHandler(worker *Worker, w *http.Response, r *http.Request) error {
+	var err error
 
-	_, err := GetFortunes(&pos, fortunes)
+	scratch := &worker.Scratch
+	scratch.Fortunes, scratch.Messages, err = GetAllFortunes(scratch.Fortunes, scratch.Messages)
 	if err != nil {
-		http.ServerError(err)
+		return http.ServerError(err)
 	}
-	fortunes = append(fortunes, Fortune{ID: database.ID(len(fortunes)), Message: "Additional fortune added at request time."})
 
-	for i := 1; i < len(fortunes); i++ {
-		for j := 0; j < i; j++ {
-			if fortunes[i].Message < fortunes[j].Message {
-				fortunes[i].ID, fortunes[j].ID = fortunes[j].ID, fortunes[i].ID
-				fortunes[i].Message, fortunes[j].Message = fortunes[j].Message, fortunes[i].Message
-			}
-		}
+	/* Additional fortune gets ID following the largest stored one, so it never collides with them. */
+	var nextID database.ID
+	for i := 0; i < len(scratch.Fortunes); i++ {
+		nextID = max(nextID, scratch.Fortunes[i].ID+1)
 	}
+	scratch.Fortunes = append(scratch.Fortunes, Fortune{ID: nextID, Message: "Additional fortune added at request time."})
+	fortunes := scratch.Fortunes
 
-	w.Headers.Set("Content-Type", `text/html; charset="UTF-8"`)
-	w.WriteString(html.Header)
+	slices.SortFunc(fortunes, func(a, b Fortune) int {
+		return strings.Compare(a.Message, b.Message)
+	})
 
-	w.WriteString(`<head>`)
-	{
-		w.WriteString(`<title>`)
-		w.WriteString("Fortunes")
-		w.WriteString(`</title>`)
-	}
-	w.WriteString(`</head>`)
-
-	w.WriteString(`<body>`)
-	{
-		w.WriteString(`<table>`)
-		w.WriteString(`<tr>`)
-		w.WriteString(`<th>`)
-		w.WriteString("id")
-		w.WriteString(`</th>`)
-		w.WriteString(`<th>`)
-		w.WriteString("message")
-		w.WriteString(`</th>`)
-		w.WriteString(`</tr>`)
-		{
-			for i := 0; i < len(fortunes); i++ {
-				fortune := &fortunes[i]
-
-				w.WriteString(`<tr>`)
-
-				w.WriteString(`<td>`)
-				w.WriteID(fortune.ID)
-				w.WriteString(`</td>`)
-
-				w.WriteString(`<td>`)
-				w.WriteHTMLString(fortune.Message)
-				w.WriteString(`</td>`)
-
-				w.WriteString(`</tr>`)
-			}
-		}
-		w.WriteString(`</table>`)
-	}
-	w.WriteString(`</body>`)
-
-	w.WriteString(`</html>`)
+	w.Headers.Set("Content-Type", `text/html; charset="UTF-8"`)
+	FortunesTemplate(w, fortunes)
 	return nil
 }
 
-func Router(ctx *http.Context, ws []http.Response, rs []http.Request) {
-	for i := 0; i < len(rs); i++ {
-		w := &ws[i]
-		r := &rs[i]
-
-		if r.URL.Path == "/plaintext" {
-			w.WriteString("Hello, world!\n")
-		} else if r.URL.Path == "/fortunes" {
-			if err := FortunesHandler(w, r); err != nil {
-				httpError, ok := err.(http.Error)
-				if ok {
-					w.StatusCode = httpError.StatusCode
-					w.WriteString(httpError.DisplayMessage)
-				} else {
-					w.StatusCode = http.StatusInternalServerError
-					w.WriteString(err.Error())
-				}
-			}
-		}
-	}
-}
-
 func GetDateHeader() []byte {
 	return unsafe.Slice((*byte)(atomic.LoadPointer(&DateBufferPtr)), time.RFC822Len)
 }
@@ -159,159 +119,116 @@ func UpdateDateHeader(now int) {
 	atomic.StorePointer(&DateBufferPtr, unsafe.Pointer(&buffer[0]))
 }
 
-func ServerWorker(q *event.Queue) {
-	events := make([]event.Event, 64)
-
-	const batchSize = 32
-	ws := make([]http.Response, batchSize)
-	rs := make([]http.Request, batchSize)
+/* PrepareDatabases checks or seeds both databases according to Config.DBMode and loads world cache. Fortunes must be already open. */
+func PrepareDatabases() error {
+	var err error
 
-	for {
-		n, err := q.GetEvents(events)
-		if err != nil {
-			log.Errorf("Failed to get events from client queue: %v", err)
-			continue
-		}
-		dateBuffer := GetDateHeader()
+	if err := PrepareDB(FortunesDB, "fortunes", func(fortune *Fortune) database.ID { return RecordKey(fortune.ID) }, DropFortunes, CreateFortunes); err != nil {
+		return fmt.Errorf("failed to prepare fortunes: %w", err)
+	}
 
-		for i := 0; i < n; i++ {
-			e := &events[i]
-			if errno := e.Error(); errno != 0 {
-				log.Errorf("Event for %v returned code %d (%s)", e.Identifier, errno, errno)
-				continue
-			}
+	WorldDB, err = database.Open(Config.WorldDBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open world DB file: %w", err)
+	}
+	if err := PrepareDB(WorldDB, "world", func(world *World) database.ID { return RecordKey(world.ID) }, DropWorlds, CreateWorlds); err != nil {
+		return fmt.Errorf("failed to prepare worlds: %w", err)
+	}
+	if err := LoadWorldCache(); err != nil {
+		return fmt.Errorf("failed to load world cache: %w", err)
+	}
 
-			ctx, ok := http.GetContextFromPointer(e.UserData)
-			if !ok {
-				continue
-			}
-			if e.EndOfFile() {
-				http.Close(ctx)
-				continue
-			}
+	return nil
+}
 
-			switch e.Type {
-			case event.Read:
-				var read int
-				for read < e.Data {
-					n, err := http.Read(ctx)
-					if err != nil {
-						if err == http.NoSpaceLeft {
-							http1.FillError(ctx, err, dateBuffer)
-							http.CloseAfterWrite(ctx)
-							break
-						}
-						log.Errorf("Failed to read data from client: %v", err)
-						http.Close(ctx)
-						break
-					}
-					read += n
-
-					for n > 0 {
-						n, err = http1.ParseRequestsUnsafe(ctx, rs)
-						if err != nil {
-							http1.FillError(ctx, err, dateBuffer)
-							http.CloseAfterWrite(ctx)
-							break
-						}
-						Router(ctx, ws[:n], rs[:n])
-						http1.FillResponses(ctx, ws[:n], dateBuffer)
-					}
-				}
-				fallthrough
-			case event.Write:
-				_, err = http.Write(ctx)
-				if err != nil {
-					log.Errorf("Failed to write data to client: %v", err)
-					http.Close(ctx)
-					continue
-				}
-			}
-		}
+func RegisterRoutes() {
+	Handle("GET", "/plaintext", PlaintextHandler)
+	Handle("GET", "/json", JSONHandler)
+	Handle("GET", "/db", DBHandler)
+	Handle("GET", "/queries", QueriesHandler)
+	Handle("GET", "/updates", UpdatesHandler)
+	Handle("GET", "/cached-queries", CachedQueriesHandler)
+	Handle("GET", "/fortunes", FortunesHandler)
+	Handle("POST", "/fortunes", CreateFortuneHandler)
+	Handle("GET", "/metrics", MetricsHandler)
+	if len(Config.StaticDir) > 0 {
+		Handle("GET", Config.StaticPrefix, StaticHandler)
 	}
 }
 
-func CreateFortunes() error {
-	fortunes := [...]Fortune{
-		{Message: `fortune: No such file or directory`},
-		{Message: `A computer scientist is someone who fixes things that aren't broken.`},
-		{Message: `After enough decimal places, nobody gives a damn.`},
-		{Message: `A bad random number generator: 1, 1, 1, 1, 1, 4.33e+67, 1, 1, 1`},
-		{Message: `A computer program does what you tell it to do, not what you want it to do.`},
-		{Message: `Emacs is a nice operating system, but I prefer UNIX. — Tom Christaensen`},
-		{Message: `Any program that runs right is obsolete.`},
-		{Message: `A list is only as strong as its weakest link. — Donald Knuth`},
-		{Message: `Feature: A bug with seniority.`},
-		{Message: `Computers make very fast, very accurate mistakes.`},
-		{Message: `<script>alert("This should not be displayed in a browser alert box.");</script>`},
-		{Message: `フレームワークのベンチマーク`},
-	}
+/* Server owns main event queue, which accepts connections in single accept mode and keeps date header up to date, and all workers. */
+type Server struct {
+	Queue    *event.Queue
+	Listener int32
+	Pool     alloc.SyncPool[http.Context]
 
-	if err := database.Drop(FortunesDB); err != nil {
-		return fmt.Errorf("failed to drop fortunes data: %w", err)
-	}
-	for i := 0; i < len(fortunes); i++ {
-		if err := CreateFortune(&fortunes[i]); err != nil {
-			return fmt.Errorf("failed to create fortune %d: %w", err)
-		}
-	}
+	Now     int
+	Counter int
 
-	return nil
+	Quit      atomic.Bool
+	WaitGroup sync.WaitGroup
 }
 
-func main() {
+/* NewServer starts listening on Config.Address and runs workers. Routes must be registered before that. */
+func NewServer() (*Server, error) {
 	var err error
 
-	FortunesDB, err = database.Open("Fortunes.db")
+	server := new(Server)
+	server.Listener = -1
+	server.Queue, err = event.NewQueue()
 	if err != nil {
-		log.Fatalf("Failed to open fortunes DB file: %v", err)
+		return nil, fmt.Errorf("failed to create listener event queue: %w", err)
 	}
-	defer database.Close(FortunesDB)
+	_ = server.Queue.AddTimer(1, 1, event.Seconds, nil)
 
-	if err := CreateFortunes(); err != nil {
-		log.Fatalf("Failed to create fortunes: %v", err)
+	server.Pool = alloc.NewSyncPool[http.Context](Config.Workers * Config.ContextsPerWorker)
+	Workers = make([]*Worker, Config.Workers)
+	for i := 0; i < len(Workers); i++ {
+		Workers[i], err = NewWorker(&server.Pool)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create new client queue: %w", err)
+		}
 	}
 
-	const address = "0.0.0.0:7073"
-	l, err := tcp.Listen(address, 128)
-	if err != nil {
-		log.Fatalf("Failed to listen on port: %v", err)
+	switch Config.Accept {
+	case AcceptSingle:
+		server.Listener, err = tcp.Listen(Config.Address, Config.Backlog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on port: %w", err)
+		}
+		_ = server.Queue.AddSocket(server.Listener, event.RequestRead, event.TriggerEdge, nil)
+	case AcceptReusePort:
+		if err := ListenWorkers(Workers, Config.Address, Config.Backlog); err != nil {
+			return nil, err
+		}
 	}
-	defer syscall.Close(l)
 
-	log.Infof("Listening on %s...", address)
+	server.Now = time.Unix()
+	UpdateDateHeader(server.Now)
 
-	q, err := event.NewQueue()
-	if err != nil {
-		log.Fatalf("Failed to create listener event queue: %v", err)
+	for i := 0; i < len(Workers); i++ {
+		server.WaitGroup.Add(1)
+		go ServerWorker(Workers[i], &server.WaitGroup)
 	}
-	defer q.Close()
 
-	_ = q.AddSocket(l, event.RequestRead, event.TriggerEdge, nil)
-	_ = q.AddTimer(1, 1, event.Seconds, nil)
-
-	_ = syscall.IgnoreSignals(syscall.SIGINT, syscall.SIGTERM)
-	_ = q.AddSignals(syscall.SIGINT, syscall.SIGTERM)
+	return server, nil
+}
 
-	nworkers := min(runtime.GOMAXPROCS(0)/2, runtime.NumCPU())
-	ctxPool := alloc.NewSyncPool[http.Context](nworkers * 512)
-	qs := make([]*event.Queue, nworkers)
-	for i := 0; i < nworkers; i++ {
-		qs[i], err = event.NewQueue()
-		if err != nil {
-			log.Fatalf("Failed to create new client queue: %v", err)
-		}
-		go ServerWorker(qs[i])
+/* Port returns port server actually listens on, which matters when Config.Address has port 0. */
+func (server *Server) Port() (int, error) {
+	l := server.Listener
+	if l == -1 {
+		l = Workers[0].Listener
 	}
-	now := time.Unix()
-	UpdateDateHeader(now)
+	return ListenerPort(l)
+}
 
-	events := make([]event.Event, 64)
-	var counter int
+/* Run handles main queue events until signal is received or server.Quit is set. Latter is noticed on the next timer tick. */
+func (server *Server) Run() {
+	events := make([]event.Event, Config.EventsSize)
 
-	var quit bool
-	for !quit {
-		n, err := q.GetEvents(events)
+	for !server.Quit.Load() {
+		n, err := server.Queue.GetEvents(events)
 		if err != nil {
 			log.Errorf("Failed to get events: %v", err)
 			continue
@@ -324,26 +241,72 @@ func main() {
 			default:
 				log.Panicf("Unhandled event: %#v", e)
 			case event.Read:
-				ctx, err := http.Accept(l, &ctxPool, 1024)
-				if err != nil {
-					if err == http.TooManyClients {
-						http1.FillError(ctx, err, GetDateHeader())
-						http.Write(ctx)
-						http.Close(ctx)
-					}
-					log.Errorf("Failed to accept new HTTP connection: %v", err)
-					continue
-				}
-				_ = qs[counter%len(qs)].AddHTTP(ctx, event.RequestRead, event.TriggerEdge)
-				counter++
+				AcceptConnection(server.Listener, &server.Pool, Workers[server.Counter%len(Workers)])
+				server.Counter++
 			case event.Timer:
-				now += e.Data
-				UpdateDateHeader(now)
+				server.Now += e.Data
+				UpdateDateHeader(server.Now)
 			case event.Signal:
 				log.Infof("Received signal %d, exitting...", e.Identifier)
-				quit = true
-				break
+				server.Quit.Store(true)
 			}
 		}
 	}
 }
+
+/* Shutdown closes listeners and waits for workers to flush pending responses. */
+func (server *Server) Shutdown() {
+	if server.Listener != -1 {
+		CloseListener(server.Listener)
+	}
+	for i := 0; i < len(Workers); i++ {
+		Workers[i].Quit.Store(true)
+		if err := Workers[i].Wake(); err != nil {
+			log.Errorf("Failed to wake worker %d up: %v", i, err)
+		}
+	}
+	server.WaitGroup.Wait()
+	for i := 0; i < len(Workers); i++ {
+		Workers[i].Queue.Close()
+	}
+	server.Queue.Close()
+}
+
+func main() {
+	if err := ParseConfig(); err != nil {
+		log.Fatalf("Failed to parse configuration: %v", err)
+	}
+	log.Infof("Starting with %s", &Config)
+
+	if err := OpenFortunes(Config.FortunesDBPath, Config.FortunesBlobPath); err != nil {
+		log.Fatalf("Failed to open fortunes: %v", err)
+	}
+	defer CloseFortunes()
+
+	if len(Config.ImportPath) > 0 {
+		n, err := ImportFortunes(Config.ImportPath)
+		if err != nil {
+			log.Fatalf("Failed to import fortunes: %v", err)
+		}
+		log.Infof("Imported %d fortunes from %s, serve them with -db-mode=%s", n, Config.ImportPath, DBModePersist)
+		return
+	}
+
+	if err := PrepareDatabases(); err != nil {
+		log.Fatalf("Failed to prepare databases: %v", err)
+	}
+	defer database.Close(WorldDB)
+
+	RegisterRoutes()
+
+	server, err := NewServer()
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	_ = syscall.IgnoreSignals(syscall.SIGINT, syscall.SIGTERM)
+	_ = server.Queue.AddSignals(syscall.SIGINT, syscall.SIGTERM)
+
+	log.Infof("Listening on %s...", Config.Address)
+	server.Run()
+	server.Shutdown()
+}
@@ -0,0 +1,919 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	stdhttp "net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	stdsyscall "syscall"
+	"testing"
+	"text/template"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/alloc"
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/net/http"
+)
+
+/* TestAddress is where server started by TestMain listens. */
+var TestAddress string
+
+/* Static files served by test server. The large one doesn't fit into socket buffers, so sendfile(2) can't send it at once. */
+var (
+	StaticHello = "Hello, static world!\n"
+	StaticLarge = strings.Repeat("0123456789abcdef", 256*1024)
+)
+
+/* Response is stdhttp.Response with body read in advance, so it can be checked multiple times. */
+type Response struct {
+	*stdhttp.Response
+	Body string
+}
+
+type Client struct {
+	Conn   net.Conn
+	Reader *bufio.Reader
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(RunTestServer(m))
+}
+
+func RunTestServer(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "gofa-test-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create temporary directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	staticDir := filepath.Join(dir, "static")
+	if err := os.Mkdir(staticDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create static directory: %v\n", err)
+		return 1
+	}
+	for name, contents := range map[string]string{"hello.txt": StaticHello, "large.bin": StaticLarge} {
+		if err := os.WriteFile(filepath.Join(staticDir, name), []byte(contents), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create static file: %v\n", err)
+			return 1
+		}
+	}
+
+	Config = Configuration{
+		Address:           "127.0.0.1:0",
+		Backlog:           128,
+		Accept:            AcceptSingle,
+		Workers:           2,
+		ContextsPerWorker: 64,
+		BufferSize:        1024,
+		BatchSize:         32,
+		EventsSize:        64,
+		ShutdownTimeout:   1,
+		IdleTimeout:       60,
+		HeaderTimeout:     10,
+		WriteTimeout:      10,
+		DBMode:            DBModeReset,
+		FortunesDBPath:    filepath.Join(dir, "Fortunes.db"),
+		FortunesBlobPath:  filepath.Join(dir, "Fortunes.blob"),
+		WorldDBPath:       filepath.Join(dir, "World.db"),
+		MaxFortuneLength:  64 * 1024,
+		MaxBodySize:       512,
+		StaticDir:         staticDir,
+		StaticPrefix:      "/static/",
+		Sendfile:          true,
+		Compress:          true,
+		CompressMinSize:   256,
+	}
+	if err := CheckConfig(&Config); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid test configuration: %v\n", err)
+		return 1
+	}
+
+	if err := OpenFortunes(Config.FortunesDBPath, Config.FortunesBlobPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open fortunes: %v\n", err)
+		return 1
+	}
+	defer CloseFortunes()
+
+	if err := PrepareDatabases(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to prepare databases: %v\n", err)
+		return 1
+	}
+	defer database.Close(WorldDB)
+
+	RegisterRoutes()
+
+	server, err := NewServer()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start server: %v\n", err)
+		return 1
+	}
+	port, err := server.Port()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get server port: %v\n", err)
+		return 1
+	}
+	TestAddress = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	done := make(chan struct{})
+	go func() {
+		server.Run()
+		close(done)
+	}()
+
+	code := m.Run()
+
+	server.Quit.Store(true)
+	<-done
+	server.Shutdown()
+
+	return code
+}
+
+func Dial(t *testing.T) *Client {
+	t.Helper()
+	return DialAddress(t, TestAddress)
+}
+
+func DialAddress(t *testing.T, address string) *Client {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(stdtime.Now().Add(5 * stdtime.Second))
+
+	return &Client{Conn: conn, Reader: bufio.NewReader(conn)}
+}
+
+/* Pipeline sends all requests with a single write and reads as many responses. */
+func (client *Client) Pipeline(t *testing.T, requests ...string) []Response {
+	t.Helper()
+
+	if _, err := client.Conn.Write([]byte(strings.Join(requests, ""))); err != nil {
+		t.Fatalf("Failed to send requests: %v", err)
+	}
+
+	responses := make([]Response, len(requests))
+	for i := 0; i < len(responses); i++ {
+		responses[i] = client.ReadResponse(t)
+	}
+	return responses
+}
+
+func (client *Client) ReadResponse(t *testing.T) Response {
+	t.Helper()
+
+	resp, err := stdhttp.ReadResponse(client.Reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	resp.Body.Close()
+
+	return Response{Response: resp, Body: string(body)}
+}
+
+func Get(path string) string {
+	return "GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"
+}
+
+func CheckResponse(t *testing.T, resp Response, status int, body string) {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Errorf("Expected status %d, got %d", status, resp.StatusCode)
+	}
+	if resp.Body != body {
+		t.Errorf("Expected body %q, got %q", body, resp.Body)
+	}
+	if expected := strconv.Itoa(len(body)); resp.Header.Get("Content-Length") != expected {
+		t.Errorf("Expected Content-Length %s, got %q", expected, resp.Header.Get("Content-Length"))
+	}
+	if len(resp.Header.Get("Date")) == 0 {
+		t.Errorf("Expected Date header")
+	}
+}
+
+func TestPlaintext(t *testing.T) {
+	const n = 16
+
+	client := Dial(t)
+	requests := make([]string, n)
+	for i := 0; i < len(requests); i++ {
+		requests[i] = Get("/plaintext")
+	}
+
+	responses := client.Pipeline(t, requests...)
+	for i := 0; i < len(responses); i++ {
+		CheckResponse(t, responses[i], stdhttp.StatusOK, "Hello, world!\n")
+	}
+}
+
+func TestPlaintextPipelineLargerThanBuffer(t *testing.T) {
+	client := Dial(t)
+
+	var requests []string
+	var size int
+	for size < 4*Config.BufferSize {
+		request := Get("/plaintext")
+		requests = append(requests, request)
+		size += len(request)
+	}
+
+	responses := client.Pipeline(t, requests...)
+	for i := 0; i < len(responses); i++ {
+		CheckResponse(t, responses[i], stdhttp.StatusOK, "Hello, world!\n")
+	}
+}
+
+/* Send writes parts one by one with pauses between them, so server is likely to get them with separate reads. */
+func (client *Client) Send(t *testing.T, parts ...string) {
+	t.Helper()
+
+	for i := 0; i < len(parts); i++ {
+		if i > 0 {
+			stdtime.Sleep(20 * stdtime.Millisecond)
+		}
+		if _, err := client.Conn.Write([]byte(parts[i])); err != nil {
+			t.Fatalf("Failed to send part %d: %v", i, err)
+		}
+	}
+}
+
+/* ExpectedFortunes renders ../std/fortunes.tmpl with the standard library, so gofa page is checked against the one other stacks produce. */
+func ExpectedFortunes(t *testing.T) string {
+	t.Helper()
+
+	type fortune struct {
+		ID      int
+		Message string
+	}
+
+	stored, _, err := GetAllFortunes(nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to get fortunes: %v", err)
+	}
+
+	var nextID int
+	fortunes := make([]fortune, 0, len(stored)+1)
+	for i := 0; i < len(stored); i++ {
+		fortunes = append(fortunes, fortune{ID: int(stored[i].ID), Message: stored[i].Message})
+		nextID = max(nextID, int(stored[i].ID)+1)
+	}
+	fortunes = append(fortunes, fortune{ID: nextID, Message: "Additional fortune added at request time."})
+	slices.SortFunc(fortunes, func(a, b fortune) int {
+		return strings.Compare(a.Message, b.Message)
+	})
+	for i := 0; i < len(fortunes); i++ {
+		fortunes[i].Message = html.EscapeString(fortunes[i].Message)
+	}
+
+	tmpl, err := template.ParseFiles(filepath.Join("..", "std", "fortunes.tmpl"))
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, fortunes); err != nil {
+		t.Fatalf("Failed to execute template: %v", err)
+	}
+	return buf.String()
+}
+
+func TestFortunes(t *testing.T) {
+	expected := ExpectedFortunes(t)
+
+	client := Dial(t)
+	responses := client.Pipeline(t, Get("/fortunes"), Get("/plaintext"), Get("/fortunes"))
+
+	CheckResponse(t, responses[1], stdhttp.StatusOK, "Hello, world!\n")
+	for _, i := range [...]int{0, 2} {
+		resp := responses[i]
+		if contentType := resp.Header.Get("Content-Type"); contentType != `text/html; charset="UTF-8"` {
+			t.Errorf("Expected HTML content type, got %q", contentType)
+		}
+		CheckResponse(t, resp, stdhttp.StatusOK, expected)
+	}
+
+	body := responses[0].Body
+	if !strings.Contains(body, `<td>&lt;script&gt;alert(&#34;This should not be displayed in a browser alert box.&#34;);&lt;/script&gt;</td>`) {
+		t.Errorf("Expected escaped script fortune in %q", body)
+	}
+	if strings.Contains(body, "<script>") {
+		t.Errorf("Unescaped script tag in %q", body)
+	}
+	if !strings.Contains(body, `<td>フレームワークのベンチマーク</td>`) {
+		t.Errorf("Expected Japanese fortune in %q", body)
+	}
+	/* Other tests may have created fortunes already. */
+	if n, expectedN := strings.Count(body, "<tr><td>"), strings.Count(expected, "<tr><td>"); n != expectedN {
+		t.Errorf("Expected %d fortunes, got %d", expectedN, n)
+	}
+}
+
+/* TestNoSpaceLeft checks that request which doesn't fit into connection buffer gets error response after responses to requests before it, that connection is closed afterwards and that worker keeps serving other clients. */
+func TestNoSpaceLeft(t *testing.T) {
+	client := Dial(t)
+
+	oversized := "GET /plaintext HTTP/1.1\r\nHost: localhost\r\nX-Padding: " + strings.Repeat("a", 2*Config.BufferSize) + "\r\n\r\n"
+	if _, err := client.Conn.Write([]byte(Get("/plaintext") + oversized)); err != nil {
+		t.Fatalf("Failed to send requests: %v", err)
+	}
+
+	CheckResponse(t, client.ReadResponse(t), stdhttp.StatusOK, "Hello, world!\n")
+	if resp := client.ReadResponse(t); (resp.StatusCode < 400) || (resp.StatusCode >= 500) {
+		t.Errorf("Expected client error for oversized request, got %d", resp.StatusCode)
+	}
+	/* Server closes connection with the rest of request unread, so it may be reset instead of closed gracefully. */
+	if _, err := client.Reader.ReadByte(); (err != io.EOF) && !errors.Is(err, stdsyscall.ECONNRESET) {
+		t.Errorf("Expected connection to be closed, got %v", err)
+	}
+
+	for i := 0; i < 2*Config.Workers; i++ {
+		other := Dial(t)
+		CheckResponse(t, other.Pipeline(t, Get("/plaintext"))[0], stdhttp.StatusOK, "Hello, world!\n")
+	}
+}
+
+/* TestWorldIDs checks that every endpoint serving worlds uses IDs in [1; WorldCount], as TechEmpower verifier requires. */
+func TestWorldIDs(t *testing.T) {
+	type world struct {
+		ID           int `json:"id"`
+		RandomNumber int `json:"randomNumber"`
+	}
+
+	client := Dial(t)
+	responses := client.Pipeline(t, Get("/db"), Get("/queries?queries=50"), Get("/cached-queries?count=50"), Get("/updates?queries=5"))
+
+	for i, resp := range responses {
+		var err error
+
+		if resp.StatusCode != stdhttp.StatusOK {
+			t.Fatalf("Expected status %d for request %d, got %d", stdhttp.StatusOK, i, resp.StatusCode)
+		}
+
+		var worlds []world
+		if i == 0 {
+			worlds = make([]world, 1)
+			err = json.Unmarshal([]byte(resp.Body), &worlds[0])
+		} else {
+			err = json.Unmarshal([]byte(resp.Body), &worlds)
+		}
+		if err != nil {
+			t.Fatalf("Failed to decode worlds from %q: %v", resp.Body, err)
+		}
+
+		for _, w := range worlds {
+			if (w.ID < 1) || (w.ID > WorldCount) {
+				t.Errorf("World ID %d is out of range [1; %d]", w.ID, WorldCount)
+			}
+			if (w.RandomNumber < 1) || (w.RandomNumber > WorldCount) {
+				t.Errorf("World %d has random number %d out of range [1; %d]", w.ID, w.RandomNumber, WorldCount)
+			}
+		}
+	}
+}
+
+func TestCreateFortune(t *testing.T) {
+	tests := [...]struct {
+		Name    string
+		Parts   []string
+		Message string
+	}{
+		{"ContentLength", []string{
+			"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 26\r\n",
+			"\r\nSplit across ",
+			"several reads",
+		}, "Split across several reads"},
+		{"Form", []string{
+			"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 21\r\n\r\nmessage=Form+fortune",
+			"!",
+		}, "Form fortune!"},
+		{"Chunked", []string{
+			"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n",
+			"7\r\nChunked",
+			"\r\n8;ext=1\r\n fortune\r\n",
+			"0\r\n",
+			"X-Trailer: ignored\r\n\r\n",
+		}, "Chunked fortune"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client := Dial(t)
+			client.Send(t, test.Parts...)
+
+			resp := client.ReadResponse(t)
+			if resp.StatusCode != stdhttp.StatusCreated {
+				t.Fatalf("Expected status %d, got %d with body %q", stdhttp.StatusCreated, resp.StatusCode, resp.Body)
+			}
+			var fortune struct {
+				ID      int    `json:"id"`
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal([]byte(resp.Body), &fortune); err != nil {
+				t.Fatalf("Failed to decode fortune from %q: %v", resp.Body, err)
+			}
+			if fortune.Message != test.Message {
+				t.Errorf("Expected message %q, got %q", test.Message, fortune.Message)
+			}
+
+			/* Connection stays usable after request with body. */
+			CheckResponse(t, client.Pipeline(t, Get("/plaintext"))[0], stdhttp.StatusOK, "Hello, world!\n")
+
+			if !strings.Contains(client.Pipeline(t, Get("/fortunes"))[0].Body, "<td>"+test.Message+"</td>") {
+				t.Errorf("Created fortune %q is not listed", test.Message)
+			}
+		})
+	}
+}
+
+/* TestCreateFortunePipelined sends request with body between two other ones in a single write, so its body has to be consumed exactly once for the next request to be found. */
+func TestCreateFortunePipelined(t *testing.T) {
+	const message = "Pipelined fortune"
+
+	client := Dial(t)
+	responses := client.Pipeline(t,
+		Get("/plaintext"),
+		"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: "+strconv.Itoa(len(message))+"\r\n\r\n"+message,
+		Get("/plaintext"),
+	)
+
+	CheckResponse(t, responses[0], stdhttp.StatusOK, "Hello, world!\n")
+	if responses[1].StatusCode != stdhttp.StatusCreated {
+		t.Errorf("Expected status %d, got %d with body %q", stdhttp.StatusCreated, responses[1].StatusCode, responses[1].Body)
+	}
+	if !strings.Contains(responses[1].Body, message) {
+		t.Errorf("Expected created fortune %q, got %q", message, responses[1].Body)
+	}
+	CheckResponse(t, responses[2], stdhttp.StatusOK, "Hello, world!\n")
+}
+
+func TestCreateFortuneErrors(t *testing.T) {
+	tests := [...]struct {
+		Name   string
+		Parts  []string
+		Status int
+		Closed bool
+	}{
+		{"LengthRequired", []string{"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\n\r\n"}, stdhttp.StatusLengthRequired, false},
+		{"ContentLengthTooLarge", []string{"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 100000\r\n\r\n", "partial body"}, stdhttp.StatusRequestEntityTooLarge, true},
+		{"ChunkedTooLarge", []string{
+			"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n",
+			"200\r\n" + strings.Repeat("a", 0x200) + "\r\n",
+			"10\r\n" + strings.Repeat("b", 0x10) + "\r\n",
+		}, stdhttp.StatusRequestEntityTooLarge, true},
+		{"MalformedChunk", []string{"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n", "zz\r\n"}, stdhttp.StatusBadRequest, true},
+		{"BothLengths", []string{"POST /fortunes HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"}, stdhttp.StatusBadRequest, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client := Dial(t)
+			client.Send(t, test.Parts...)
+
+			if resp := client.ReadResponse(t); resp.StatusCode != test.Status {
+				t.Errorf("Expected status %d, got %d", test.Status, resp.StatusCode)
+			}
+			if test.Closed {
+				if _, err := client.Reader.ReadByte(); (err != io.EOF) && !errors.Is(err, stdsyscall.ECONNRESET) {
+					t.Errorf("Expected connection to be closed, got %v", err)
+				}
+			} else {
+				CheckResponse(t, client.Pipeline(t, Get("/plaintext"))[0], stdhttp.StatusOK, "Hello, world!\n")
+			}
+		})
+	}
+}
+
+/* OpenContexts returns the number of connections workers still track. */
+func OpenContexts() int64 {
+	var n int64
+	for i := 0; i < len(Workers); i++ {
+		n += Workers[i].Metrics.OpenContexts.Load()
+	}
+	return n
+}
+
+/* TestCloseReleasesContext checks that connections closed by server after their last response are removed from workers, so their contexts can't be expired or flushed once they are reused. */
+func TestCloseReleasesContext(t *testing.T) {
+	tests := [...]struct {
+		Name     string
+		Requests string
+		Status   int
+	}{
+		{"Rejected", "GET /plaintext HTTP/1.1\r\nHost: localhost\r\nContent-Length: x\r\n\r\n" + Get("/plaintext"), stdhttp.StatusBadRequest},
+		{"ConnectionClose", "GET /plaintext HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n" + Get("/plaintext"), stdhttp.StatusOK},
+		{"HTTP10", "GET /plaintext HTTP/1.0\r\n\r\n" + Get("/plaintext"), stdhttp.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client := Dial(t)
+			client.Send(t, test.Requests)
+
+			if resp := client.ReadResponse(t); resp.StatusCode != test.Status {
+				t.Errorf("Expected status %d, got %d", test.Status, resp.StatusCode)
+			}
+			/* Requests after the closing one are not answered. */
+			if _, err := client.Reader.ReadByte(); (err != io.EOF) && !errors.Is(err, stdsyscall.ECONNRESET) {
+				t.Fatalf("Expected connection to be closed, got %v", err)
+			}
+
+			deadline := stdtime.Now().Add(2 * stdtime.Second)
+			for OpenContexts() > 0 {
+				if stdtime.Now().After(deadline) {
+					t.Fatalf("Expected all contexts to be released, %d are still open", OpenContexts())
+				}
+				stdtime.Sleep(10 * stdtime.Millisecond)
+			}
+		})
+	}
+}
+
+/* TestReusePort runs workers with their own SO_REUSEPORT listeners next to the main test server and checks that all of them share one port, even when it is chosen by kernel. */
+func TestReusePort(t *testing.T) {
+	for _, address := range [...]string{"127.0.0.1:0", "[::1]:0"} {
+		t.Run(address, func(t *testing.T) {
+			pool := alloc.NewSyncPool[http.Context](2 * Config.ContextsPerWorker)
+			workers := make([]*Worker, 2)
+			for i := 0; i < len(workers); i++ {
+				var err error
+
+				workers[i], err = NewWorker(&pool)
+				if err != nil {
+					t.Fatalf("Failed to create worker: %v", err)
+				}
+				defer workers[i].Queue.Close()
+			}
+
+			if err := ListenWorkers(workers, address, Config.Backlog); err != nil {
+				if errors.Is(err, stdsyscall.EADDRNOTAVAIL) || errors.Is(err, stdsyscall.EAFNOSUPPORT) {
+					t.Skipf("Address %s is not available: %v", address, err)
+				}
+				t.Fatalf("Failed to listen: %v", err)
+			}
+			port, err := ListenerPort(workers[0].Listener)
+			if err != nil {
+				t.Fatalf("Failed to get listener port: %v", err)
+			}
+			for i := 1; i < len(workers); i++ {
+				if other, _ := ListenerPort(workers[i].Listener); other != port {
+					t.Fatalf("Expected worker %d to listen on port %d, got %d", i, port, other)
+				}
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < len(workers); i++ {
+				wg.Add(1)
+				go ServerWorker(workers[i], &wg)
+			}
+			defer func() {
+				for i := 0; i < len(workers); i++ {
+					workers[i].Quit.Store(true)
+					workers[i].Wake()
+				}
+				wg.Wait()
+			}()
+
+			host, _, _ := net.SplitHostPort(address)
+			for i := 0; i < 16; i++ {
+				client := DialAddress(t, net.JoinHostPort(host, strconv.Itoa(port)))
+				responses := client.Pipeline(t, Get("/plaintext"), Get("/json"))
+				CheckResponse(t, responses[0], stdhttp.StatusOK, "Hello, world!\n")
+				CheckResponse(t, responses[1], stdhttp.StatusOK, `{"message":"Hello, World!"}`)
+				client.Conn.Close()
+			}
+		})
+	}
+}
+
+/* TestMetrics scrapes /metrics after a request to known route and checks that it is counted. */
+func TestMetrics(t *testing.T) {
+	client := Dial(t)
+
+	responses := client.Pipeline(t, Get("/plaintext"), Get("/metrics"))
+	CheckResponse(t, responses[0], stdhttp.StatusOK, "Hello, world!\n")
+
+	resp := responses[1]
+	if resp.StatusCode != stdhttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d", stdhttp.StatusOK, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Expected Prometheus text format, got Content-Type %q", contentType)
+	}
+
+	values := make(map[string]int64)
+	for _, line := range strings.Split(strings.TrimSuffix(resp.Body, "\n"), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		space := strings.LastIndexByte(line, ' ')
+		if space == -1 {
+			t.Fatalf("Malformed metric line %q", line)
+		}
+		if value, err := strconv.ParseInt(line[space+1:], 10, 64); err == nil {
+			values[line[:space]] = value
+		}
+	}
+
+	for _, metric := range [...]string{`gofa_requests_total{route="/plaintext"}`, `gofa_responses_total{code="200"}`, "gofa_request_duration_seconds_count"} {
+		if values[metric] < 1 {
+			t.Errorf("Expected %s to be at least 1, got %d", metric, values[metric])
+		}
+	}
+	for i := 0; i < len(Workers); i++ {
+		metric := `gofa_open_contexts{worker="` + strconv.Itoa(i) + `"}`
+		if _, ok := values[metric]; !ok {
+			t.Errorf("Expected %s to be reported", metric)
+		}
+	}
+	if values[`gofa_request_duration_seconds_bucket{le="+Inf"}`] != values["gofa_request_duration_seconds_count"] {
+		t.Errorf("Expected +Inf bucket to be equal to count")
+	}
+}
+
+/* ReadHead reads status line and headers of response without body, like response to HEAD, which stdhttp.ReadResponse can't tell from GET one. */
+func (client *Client) ReadHead(t *testing.T) []string {
+	t.Helper()
+
+	var lines []string
+	for {
+		line, err := client.Reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read response head: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		if len(line) == 0 {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+/* HeaderLines returns values of all name headers in head returned by ReadHead. */
+func HeaderLines(head []string, name string) []string {
+	var values []string
+	for _, line := range head[1:] {
+		if key, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(key, name) {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	return values
+}
+
+func GetWithHeaders(path string, headers string) string {
+	return "GET " + path + " HTTP/1.1\r\nHost: localhost\r\n" + headers + "\r\n"
+}
+
+func TestStatic(t *testing.T) {
+	client := Dial(t)
+
+	/* Response that is the only one in batch is sent with sendfile(2), the one followed by another request is copied, but their heads must be the same. */
+	responses := client.Pipeline(t, Get("/static/hello.txt"), Get("/plaintext"))
+	copied := responses[0]
+	CheckResponse(t, copied, stdhttp.StatusOK, StaticHello)
+	sent := client.Pipeline(t, Get("/static/hello.txt"))[0]
+	CheckResponse(t, sent, stdhttp.StatusOK, StaticHello)
+
+	for key := range copied.Header {
+		if (key != "Date") && !slices.Equal(copied.Header[key], sent.Header[key]) {
+			t.Errorf("Expected %s header of sendfile(2) response to be %q, got %q", key, copied.Header[key], sent.Header[key])
+		}
+	}
+	for key := range sent.Header {
+		if _, ok := copied.Header[key]; !ok {
+			t.Errorf("Unexpected %s header in sendfile(2) response", key)
+		}
+	}
+	if server := responses[1].Header.Get("Server"); sent.Header.Get("Server") != server {
+		t.Errorf("Expected Server header %q, got %q", server, sent.Header.Get("Server"))
+	}
+	if contentType := sent.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Expected text/plain Content-Type, got %q", contentType)
+	}
+
+	etag := sent.Header.Get("ETag")
+	lastModified := sent.Header.Get("Last-Modified")
+	if (len(etag) == 0) || (len(lastModified) == 0) {
+		t.Fatalf("Expected validators, got ETag %q and Last-Modified %q", etag, lastModified)
+	}
+	size := strconv.Itoa(len(StaticHello))
+
+	tests := [...]struct {
+		Name         string
+		Headers      string
+		Status       int
+		Body         string
+		ContentRange string
+	}{
+		{"Range", "Range: bytes=7-11\r\n", stdhttp.StatusPartialContent, StaticHello[7:12], "bytes 7-11/" + size},
+		{"SuffixRange", "Range: bytes=-7\r\n", stdhttp.StatusPartialContent, StaticHello[len(StaticHello)-7:], "bytes " + strconv.Itoa(len(StaticHello)-7) + "-" + strconv.Itoa(len(StaticHello)-1) + "/" + size},
+		{"RangeNotSatisfiable", "Range: bytes=100-\r\n", stdhttp.StatusRequestedRangeNotSatisfiable, "", "bytes */" + size},
+		{"MultipleRanges", "Range: bytes=0-1,3-4\r\n", stdhttp.StatusOK, StaticHello, ""},
+		{"IfRangeMatches", "Range: bytes=0-4\r\nIf-Range: " + etag + "\r\n", stdhttp.StatusPartialContent, StaticHello[:5], "bytes 0-4/" + size},
+		{"IfRangeStale", "Range: bytes=0-4\r\nIf-Range: \"stale\"\r\n", stdhttp.StatusOK, StaticHello, ""},
+		{"IfNoneMatch", "If-None-Match: " + etag + "\r\n", stdhttp.StatusNotModified, "", ""},
+		{"IfModifiedSince", "If-Modified-Since: " + lastModified + "\r\n", stdhttp.StatusNotModified, "", ""},
+		{"IfMatch", "If-Match: " + etag + "\r\n", stdhttp.StatusOK, StaticHello, ""},
+		{"IfMatchFailed", "If-Match: \"stale\"\r\n", stdhttp.StatusPreconditionFailed, "", ""},
+		{"IfMatchWeak", "If-Match: W/" + etag + "\r\n", stdhttp.StatusPreconditionFailed, "", ""},
+		{"IfUnmodifiedSinceFailed", "If-Unmodified-Since: Thu, 01 Jan 1970 00:00:00 GMT\r\n", stdhttp.StatusPreconditionFailed, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			resp := client.Pipeline(t, GetWithHeaders("/static/hello.txt", test.Headers))[0]
+			if resp.StatusCode == stdhttp.StatusNotModified {
+				/* 304 has no body and may have no Content-Length. */
+				if resp.StatusCode != test.Status {
+					t.Errorf("Expected status %d, got %d", test.Status, resp.StatusCode)
+				}
+			} else {
+				CheckResponse(t, resp, test.Status, test.Body)
+			}
+			if resp.Header.Get("Content-Range") != test.ContentRange {
+				t.Errorf("Expected Content-Range %q, got %q", test.ContentRange, resp.Header.Get("Content-Range"))
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		CheckResponse(t, client.Pipeline(t, Get("/static/missing.txt"))[0], stdhttp.StatusNotFound, "404 Not Found\n")
+	})
+}
+
+/* TestStaticHead checks that HEAD gets exactly one Content-Length, the one GET would get, and no body. */
+func TestStaticHead(t *testing.T) {
+	tests := [...]struct {
+		Name    string
+		Headers string
+		Status  int
+		Length  int
+	}{
+		{"Full", "", stdhttp.StatusOK, len(StaticLarge)},
+		{"Range", "Range: bytes=10-19\r\n", stdhttp.StatusPartialContent, 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client := Dial(t)
+			client.Send(t, "HEAD /static/large.bin HTTP/1.1\r\nHost: localhost\r\n"+test.Headers+"\r\n"+Get("/plaintext"))
+
+			head := client.ReadHead(t)
+			if status := "HTTP/1.1 " + strconv.Itoa(test.Status) + " "; !strings.HasPrefix(head[0], status) {
+				t.Errorf("Expected status line %q, got %q", status, head[0])
+			}
+			if lengths := HeaderLines(head, "Content-Length"); (len(lengths) != 1) || (lengths[0] != strconv.Itoa(test.Length)) {
+				t.Errorf("Expected single Content-Length %d, got %q", test.Length, lengths)
+			}
+
+			/* Body of HEAD response would be read as the next response. */
+			CheckResponse(t, client.ReadResponse(t), stdhttp.StatusOK, "Hello, world!\n")
+		})
+	}
+}
+
+/* TestStaticPipelined sends requests while large file is still being sent with sendfile(2), so they are handled only after transfer completes. */
+func TestStaticPipelined(t *testing.T) {
+	t.Run("Pipelined", func(t *testing.T) {
+		client := Dial(t)
+		client.Send(t, Get("/static/large.bin"), Get("/plaintext")+Get("/static/hello.txt"))
+
+		CheckResponse(t, client.ReadResponse(t), stdhttp.StatusOK, StaticLarge)
+		CheckResponse(t, client.ReadResponse(t), stdhttp.StatusOK, "Hello, world!\n")
+		CheckResponse(t, client.ReadResponse(t), stdhttp.StatusOK, StaticHello)
+	})
+
+	t.Run("ConnectionClose", func(t *testing.T) {
+		client := Dial(t)
+		client.Send(t, GetWithHeaders("/static/large.bin", "Connection: close\r\n")+Get("/plaintext"))
+
+		resp := client.ReadResponse(t)
+		CheckResponse(t, resp, stdhttp.StatusOK, StaticLarge)
+		if !resp.Close {
+			t.Errorf("Expected 'Connection: close' header")
+		}
+		if _, err := client.Reader.ReadByte(); (err != io.EOF) && !errors.Is(err, stdsyscall.ECONNRESET) {
+			t.Fatalf("Expected connection to be closed, got %v", err)
+		}
+	})
+}
+
+/* TestCompressHead checks that HEAD goes through the same content negotiation as GET and reports length of compressed body. */
+func TestCompressHead(t *testing.T) {
+	tests := [...]struct {
+		Name           string
+		AcceptEncoding string
+		Encoding       string
+	}{
+		{"Gzip", "gzip", "gzip"},
+		{"Deflate", "gzip;q=0.5, deflate", "deflate"},
+		{"Identity", "identity", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			headers := "Accept-Encoding: " + test.AcceptEncoding + "\r\n"
+
+			client := Dial(t)
+			client.Send(t, GetWithHeaders("/fortunes", headers)+"HEAD /fortunes HTTP/1.1\r\nHost: localhost\r\n"+headers+"\r\n")
+
+			resp := client.ReadResponse(t)
+			if resp.StatusCode != stdhttp.StatusOK {
+				t.Fatalf("Expected status %d, got %d", stdhttp.StatusOK, resp.StatusCode)
+			}
+			if encoding := resp.Header.Get("Content-Encoding"); encoding != test.Encoding {
+				t.Errorf("Expected Content-Encoding %q, got %q", test.Encoding, encoding)
+			}
+
+			head := client.ReadHead(t)
+			if encodings := HeaderLines(head, "Content-Encoding"); !slices.Equal(encodings, resp.Header.Values("Content-Encoding")) {
+				t.Errorf("Expected HEAD Content-Encoding %q, got %q", resp.Header.Values("Content-Encoding"), encodings)
+			}
+			if lengths := HeaderLines(head, "Content-Length"); (len(lengths) != 1) || (lengths[0] != strconv.Itoa(len(resp.Body))) {
+				t.Errorf("Expected single Content-Length %d, got %q", len(resp.Body), lengths)
+			}
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	client := Dial(t)
+
+	resp := client.Pipeline(t, Get("/json"))[0]
+	CheckResponse(t, resp, stdhttp.StatusOK, `{"message":"Hello, World!"}`)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %q", contentType)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	tests := [...]struct {
+		Name    string
+		Request string
+		Status  int
+		Body    string
+		Allow   string
+	}{
+		{"NotFound", Get("/missing"), stdhttp.StatusNotFound, "404 Not Found\n", ""},
+		{"NotFoundPrefix", Get("/plaintext/more"), stdhttp.StatusNotFound, "404 Not Found\n", ""},
+		{"MethodNotAllowed", "DELETE /json HTTP/1.1\r\nHost: localhost\r\n\r\n", stdhttp.StatusMethodNotAllowed, "405 Method Not Allowed\n", "GET, HEAD"},
+		{"MethodNotAllowedWithBody", "POST /plaintext HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi", stdhttp.StatusMethodNotAllowed, "405 Method Not Allowed\n", "GET, HEAD"},
+		{"UnknownMethod", "PROPFIND /fortunes HTTP/1.1\r\nHost: localhost\r\n\r\n", stdhttp.StatusMethodNotAllowed, "405 Method Not Allowed\n", "GET, HEAD, POST"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client := Dial(t)
+
+			/* Connection stays usable after errors of routing. */
+			responses := client.Pipeline(t, test.Request, Get("/plaintext"))
+			CheckResponse(t, responses[0], test.Status, test.Body)
+			if allow := responses[0].Header.Get("Allow"); allow != test.Allow {
+				t.Errorf("Expected Allow %q, got %q", test.Allow, allow)
+			}
+			CheckResponse(t, responses[1], stdhttp.StatusOK, "Hello, world!\n")
+		})
+	}
+}
+
+/* TestHead checks that HEAD gets the head GET would, with exactly one Content-Length, and no body. */
+func TestHead(t *testing.T) {
+	for _, path := range [...]string{"/plaintext", "/json", "/fortunes", "/missing"} {
+		t.Run(strings.TrimPrefix(path, "/"), func(t *testing.T) {
+			client := Dial(t)
+			client.Send(t, Get(path)+"HEAD "+path+" HTTP/1.1\r\nHost: localhost\r\n\r\n"+Get("/plaintext"))
+
+			get := client.ReadResponse(t)
+			head := client.ReadHead(t)
+			if status := "HTTP/1.1 " + strconv.Itoa(get.StatusCode) + " "; !strings.HasPrefix(head[0], status) {
+				t.Errorf("Expected status line %q, got %q", status, head[0])
+			}
+			if lengths := HeaderLines(head, "Content-Length"); (len(lengths) != 1) || (lengths[0] != strconv.Itoa(len(get.Body))) {
+				t.Errorf("Expected single Content-Length %d, got %q", len(get.Body), lengths)
+			}
+			if contentType := HeaderLines(head, "Content-Type"); !slices.Equal(contentType, get.Header.Values("Content-Type")) {
+				t.Errorf("Expected Content-Type %q, got %q", get.Header.Values("Content-Type"), contentType)
+			}
+
+			/* Body of HEAD response would be read as the next response. */
+			CheckResponse(t, client.ReadResponse(t), stdhttp.StatusOK, "Hello, world!\n")
+		})
+	}
+}
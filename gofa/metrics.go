@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+/* Metrics are mostly updated by the worker they belong to, but in single accept mode accepting goroutine also counts rejected accepts and opened contexts (see Worker.Add). Atomics make those updates, as well as concurrent reads from /metrics, safe. */
+type Metrics struct {
+	/* Requests are indexed by Route.Index; the last element counts requests to unknown paths. */
+	Requests []atomic.Int64
+	Statuses [600]atomic.Int64
+
+	BytesRead    atomic.Int64
+	BytesWritten atomic.Int64
+	ParseErrors  atomic.Int64
+
+	RejectedAccepts atomic.Int64
+	Timeouts        atomic.Int64
+	OpenContexts    atomic.Int64
+
+	LatencyBuckets [len(LatencyBuckets) + 1]atomic.Int64
+	LatencySum     atomic.Int64
+}
+
+/* LatencyBuckets are upper bounds of latency histogram buckets in microseconds. */
+var LatencyBuckets = [...]int64{10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 25000, 50000, 100000}
+
+/* Workers is the list of all running workers, used to aggregate their metrics. */
+var Workers []*Worker
+
+func (metrics *Metrics) Init() {
+	metrics.Requests = make([]atomic.Int64, len(RouteList)+1)
+}
+
+func (metrics *Metrics) ObserveRequest(route int, status http.Status, latency stdtime.Duration) {
+	metrics.Requests[route].Add(1)
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+	metrics.Statuses[min(max(int(status), 0), len(metrics.Statuses)-1)].Add(1)
+
+	us := latency.Microseconds()
+	bucket := len(LatencyBuckets)
+	for i := 0; i < len(LatencyBuckets); i++ {
+		if us <= LatencyBuckets[i] {
+			bucket = i
+			break
+		}
+	}
+	metrics.LatencyBuckets[bucket].Add(1)
+	metrics.LatencySum.Add(int64(latency))
+}
+
+func AppendMetric(buf []byte, name string, labels string, value int64) []byte {
+	buf = append(buf, name...)
+	if len(labels) > 0 {
+		buf = append(buf, '{')
+		buf = append(buf, labels...)
+		buf = append(buf, '}')
+	}
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, value, 10)
+	buf = append(buf, '\n')
+	return buf
+}
+
+func AppendMetricHeader(buf []byte, name string, typ string, help string) []byte {
+	buf = append(buf, "# HELP "...)
+	buf = append(buf, name...)
+	buf = append(buf, ' ')
+	buf = append(buf, help...)
+	buf = append(buf, "\n# TYPE "...)
+	buf = append(buf, name...)
+	buf = append(buf, ' ')
+	buf = append(buf, typ...)
+	buf = append(buf, '\n')
+	return buf
+}
+
+func SumMetric(get func(metrics *Metrics) *atomic.Int64) int64 {
+	var sum int64
+	for i := 0; i < len(Workers); i++ {
+		sum += get(&Workers[i].Metrics).Load()
+	}
+	return sum
+}
+
+/* MetricsHandler renders metrics of all workers in Prometheus text exposition format. */
+func MetricsHandler(worker *Worker, w *http.Response, r *http.Request) error {
+	buf := worker.Scratch.Metrics[:0]
+
+	buf = AppendMetricHeader(buf, "gofa_requests_total", "counter", "Number of handled requests by route.")
+	for i := 0; i <= len(RouteList); i++ {
+		path := "unknown"
+		if i < len(RouteList) {
+			path = RouteList[i].Path
+		}
+		buf = AppendMetric(buf, "gofa_requests_total", `route="`+path+`"`, SumMetric(func(metrics *Metrics) *atomic.Int64 { return &metrics.Requests[i] }))
+	}
+
+	buf = AppendMetricHeader(buf, "gofa_responses_total", "counter", "Number of responses by status code.")
+	for status := 0; status < len(worker.Metrics.Statuses); status++ {
+		if value := SumMetric(func(metrics *Metrics) *atomic.Int64 { return &metrics.Statuses[status] }); value > 0 {
+			buf = AppendMetric(buf, "gofa_responses_total", `code="`+strconv.Itoa(status)+`"`, value)
+		}
+	}
+
+	buf = AppendMetricHeader(buf, "gofa_read_bytes_total", "counter", "Number of bytes read from clients.")
+	buf = AppendMetric(buf, "gofa_read_bytes_total", "", SumMetric(func(metrics *Metrics) *atomic.Int64 { return &metrics.BytesRead }))
+
+	buf = AppendMetricHeader(buf, "gofa_written_bytes_total", "counter", "Number of bytes written to clients.")
+	buf = AppendMetric(buf, "gofa_written_bytes_total", "", SumMetric(func(metrics *Metrics) *atomic.Int64 { return &metrics.BytesWritten }))
+
+	buf = AppendMetricHeader(buf, "gofa_parse_errors_total", "counter", "Number of malformed requests.")
+	buf = AppendMetric(buf, "gofa_parse_errors_total", "", SumMetric(func(metrics *Metrics) *atomic.Int64 { return &metrics.ParseErrors }))
+
+	buf = AppendMetricHeader(buf, "gofa_rejected_accepts_total", "counter", "Number of connections rejected because there were too many clients.")
+	buf = AppendMetric(buf, "gofa_rejected_accepts_total", "", SumMetric(func(metrics *Metrics) *atomic.Int64 { return &metrics.RejectedAccepts }))
+
+	buf = AppendMetricHeader(buf, "gofa_timeouts_total", "counter", "Number of connections closed because of idle, header or write timeout.")
+	buf = AppendMetric(buf, "gofa_timeouts_total", "", SumMetric(func(metrics *Metrics) *atomic.Int64 { return &metrics.Timeouts }))
+
+	buf = AppendMetricHeader(buf, "gofa_open_contexts", "gauge", "Number of open HTTP contexts.")
+	for i := 0; i < len(Workers); i++ {
+		buf = AppendMetric(buf, "gofa_open_contexts", `worker="`+strconv.Itoa(i)+`"`, Workers[i].Metrics.OpenContexts.Load())
+	}
+
+	buf = AppendMetricHeader(buf, "gofa_request_duration_seconds", "histogram", "Time spent handling requests.")
+	var count int64
+	for i := 0; i <= len(LatencyBuckets); i++ {
+		count += SumMetric(func(metrics *Metrics) *atomic.Int64 { return &metrics.LatencyBuckets[i] })
+
+		le := "+Inf"
+		if i < len(LatencyBuckets) {
+			le = strconv.FormatFloat(float64(LatencyBuckets[i])/1e6, 'g', -1, 64)
+		}
+		buf = AppendMetric(buf, "gofa_request_duration_seconds_bucket", `le="`+le+`"`, count)
+	}
+	buf = append(buf, "gofa_request_duration_seconds_sum "...)
+	buf = strconv.AppendFloat(buf, stdtime.Duration(SumMetric(func(metrics *Metrics) *atomic.Int64 { return &metrics.LatencySum })).Seconds(), 'g', -1, 64)
+	buf = append(buf, '\n')
+	buf = AppendMetric(buf, "gofa_request_duration_seconds_count", "", count)
+
+	worker.Scratch.Metrics = buf
+
+	w.Headers.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf)
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"strings"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+type HandlerFunc func(worker *Worker, w *http.Response, r *http.Request) error
+
+type Method int
+
+const (
+	MethodGet Method = iota
+	MethodHead
+	MethodPost
+	MethodPut
+	MethodPatch
+	MethodDelete
+	MethodOptions
+	MethodCount
+	MethodUnknown = MethodCount
+)
+
+var MethodNames = [MethodCount]string{
+	MethodGet:     "GET",
+	MethodHead:    "HEAD",
+	MethodPost:    "POST",
+	MethodPut:     "PUT",
+	MethodPatch:   "PATCH",
+	MethodDelete:  "DELETE",
+	MethodOptions: "OPTIONS",
+}
+
+type Route struct {
+	Path  string
+	Index int
+
+	/* Prefix routes are registered with trailing slash and match every path below them. */
+	Prefix bool
+
+	Handlers [MethodCount]HandlerFunc
+
+	/* Allow is the value of 'Allow' header sent with 405 responses. */
+	Allow string
+}
+
+var (
+	Routes    = make(map[string]*Route)
+	RouteList []*Route
+)
+
+func ParseMethod(method string) Method {
+	for m := Method(0); m < MethodCount; m++ {
+		if method == MethodNames[m] {
+			return m
+		}
+	}
+	return MethodUnknown
+}
+
+/* Handle registers handler for method and path. Paths with GET handlers also answer HEAD unless it is registered explicitly. */
+func Handle(method string, path string, handler HandlerFunc) {
+	m := ParseMethod(method)
+	if m == MethodUnknown {
+		panic("unsupported method " + method)
+	}
+
+	route, ok := Routes[path]
+	if !ok {
+		route = &Route{Path: path, Index: len(RouteList), Prefix: strings.HasSuffix(path, "/")}
+		Routes[path] = route
+		RouteList = append(RouteList, route)
+	}
+	if route.Handlers[m] != nil {
+		panic("multiple registrations for " + method + " " + path)
+	}
+	route.Handlers[m] = handler
+
+	route.Allow = ""
+	for m := Method(0); m < MethodCount; m++ {
+		if (route.Handlers[m] != nil) || ((m == MethodHead) && (route.Handlers[MethodGet] != nil)) {
+			if len(route.Allow) > 0 {
+				route.Allow += ", "
+			}
+			route.Allow += MethodNames[m]
+		}
+	}
+}
+
+func NotFound(w *http.Response) {
+	w.StatusCode = http.StatusNotFound
+	w.Headers.Set("Content-Type", `text/plain; charset="UTF-8"`)
+	w.WriteString("404 Not Found\n")
+}
+
+func MethodNotAllowed(w *http.Response, allow string) {
+	w.StatusCode = http.StatusMethodNotAllowed
+	w.Headers.Set("Allow", allow)
+	w.Headers.Set("Content-Type", `text/plain; charset="UTF-8"`)
+	w.WriteString("405 Method Not Allowed\n")
+}
+
+/* WriteError replaces whatever handler has written before failing, like first objects of JSON array, with error message. */
+func WriteError(w *http.Response, err error) {
+	w.Headers = http.Headers{}
+	w.Headers.Set("Content-Type", `text/plain; charset="UTF-8"`)
+	w.Body = w.Body[:0]
+
+	httpError, ok := err.(http.Error)
+	if ok {
+		w.StatusCode = httpError.StatusCode
+		w.WriteString(httpError.DisplayMessage)
+	} else {
+		w.StatusCode = http.StatusInternalServerError
+		w.WriteString(err.Error())
+	}
+}
+
+/* FindRoute looks up exact match for path first and then prefix routes for each of its parent directories, longest first. */
+func FindRoute(path string) (*Route, bool) {
+	if route, ok := Routes[path]; ok {
+		return route, true
+	}
+	for slash := strings.LastIndexByte(path, '/'); slash >= 0; slash = strings.LastIndexByte(path[:slash], '/') {
+		if route, ok := Routes[path[:slash+1]]; ok && route.Prefix {
+			return route, true
+		}
+	}
+	return nil, false
+}
+
+/* RouteRequest calls handler registered for r and returns index of matched route or len(RouteList), if there is none. */
+func RouteRequest(worker *Worker, w *http.Response, r *http.Request) int {
+	route, ok := FindRoute(r.URL.Path)
+	if !ok {
+		NotFound(w)
+		return len(RouteList)
+	}
+
+	m := ParseMethod(r.Method)
+	if m == MethodUnknown {
+		MethodNotAllowed(w, route.Allow)
+		return route.Index
+	}
+
+	handler := route.Handlers[m]
+	if (handler == nil) && (m == MethodHead) {
+		handler = route.Handlers[MethodGet]
+	}
+	if handler == nil {
+		MethodNotAllowed(w, route.Allow)
+		return route.Index
+	}
+
+	if err := handler(worker, w, r); err != nil {
+		WriteError(w, err)
+	}
+	return route.Index
+}
+
+/* Router handles batch of requests. Static file transfer requested by the last response in batch is left in worker.Scratch for sendfile(2), others are copied into response bodies. Responses to HEAD requests are built like GET ones and lose their bodies only at the end, so their heads, Content-Length included, are the ones GET would get (RFC 9110, section 9.3.2). Lengths of bodies that are not in responses are kept in worker.Scratch.Lengths for Worker.FillResponses. */
+func Router(worker *Worker, ctx *http.Context, ws []http.Response, rs []http.Request) {
+	lengths := worker.Scratch.Lengths[:0]
+
+	for i := 0; i < len(rs); i++ {
+		start := stdtime.Now()
+		route := RouteRequest(worker, &ws[i], &rs[i])
+		head := rs[i].Method == "HEAD"
+
+		length := int64(-1)
+		if worker.Scratch.PendingTransfer {
+			t := &worker.Scratch.Transfer
+			if (!head) && Config.Sendfile && (i == len(rs)-1) {
+				length = t.Length
+			} else {
+				worker.Scratch.PendingTransfer = false
+				if head {
+					length = t.Length
+				} else if err := CopyTransfer(worker, &ws[i], t); err != nil {
+					WriteError(&ws[i], http.ServerError(err))
+				}
+				t.File.Release()
+				t.File = nil
+			}
+		}
+		CompressResponse(worker, &ws[i], &rs[i])
+		if head {
+			if length == -1 {
+				length = int64(len(ws[i].Body))
+			}
+			ws[i].Body = ws[i].Body[:0]
+		}
+		lengths = append(lengths, length)
+
+		worker.Metrics.ObserveRequest(route, ws[i].StatusCode, stdtime.Since(start))
+	}
+
+	worker.Scratch.Lengths = lengths
+}
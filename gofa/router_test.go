@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		status   http.Status
+		expected string
+	}{
+		{"HTTPError", http.Error{StatusCode: http.StatusInternalServerError, DisplayMessage: "failed to read world"}, http.StatusInternalServerError, "failed to read world"},
+		{"OtherError", errors.New("something broke"), http.StatusInternalServerError, "something broke"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var w http.Response
+
+			/* Handler failed after writing part of JSON array. */
+			w.Headers.Set("Content-Type", "application/json")
+			w.WriteString(`[{"id":1,"randomNumber":2},`)
+
+			WriteError(&w, test.err)
+			if w.StatusCode != test.status {
+				t.Errorf("Expected status %d, got %d", test.status, w.StatusCode)
+			}
+			if string(w.Body) != test.expected {
+				t.Errorf("Expected body %q, got %q", test.expected, w.Body)
+			}
+			if contentType := w.Headers.Get("Content-Type"); contentType != `text/plain; charset="UTF-8"` {
+				t.Errorf("Expected plain text error, got Content-Type %q", contentType)
+			}
+		})
+	}
+}
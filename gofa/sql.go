@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/anton2920/gofa/database"
+)
+
+/* SQLParser understands just enough of SQL to extract rows from INSERT statements produced for Postgres implementations. */
+type SQLParser struct {
+	Data string
+	Pos  int
+	Line int
+}
+
+type SQLValue struct {
+	IsString bool
+	String   string
+	Int      int
+}
+
+type SQLRow []SQLValue
+
+var SQLUnexpectedEOF = errors.New("unexpected end of input")
+
+func (p *SQLParser) Errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %w", p.Line+1, fmt.Errorf(format, args...))
+}
+
+func (p *SQLParser) SkipSpaceAndComments() {
+	for p.Pos < len(p.Data) {
+		switch c := p.Data[p.Pos]; c {
+		case '\n':
+			p.Line++
+			fallthrough
+		case ' ', '\t', '\r':
+			p.Pos++
+		case '-':
+			if (p.Pos+1 < len(p.Data)) && (p.Data[p.Pos+1] == '-') {
+				for (p.Pos < len(p.Data)) && (p.Data[p.Pos] != '\n') {
+					p.Pos++
+				}
+				continue
+			}
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (p *SQLParser) Peek() byte {
+	p.SkipSpaceAndComments()
+	if p.Pos >= len(p.Data) {
+		return 0
+	}
+	return p.Data[p.Pos]
+}
+
+func (p *SQLParser) Expect(c byte) error {
+	if got := p.Peek(); got != c {
+		if got == 0 {
+			return p.Errorf("expected %q: %w", c, SQLUnexpectedEOF)
+		}
+		return p.Errorf("expected %q, got %q", c, got)
+	}
+	p.Pos++
+	return nil
+}
+
+func IsSQLIdentChar(c byte) bool {
+	return ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')) || ((c >= '0') && (c <= '9')) || (c == '_')
+}
+
+func (p *SQLParser) Ident() string {
+	p.SkipSpaceAndComments()
+	start := p.Pos
+	for (p.Pos < len(p.Data)) && IsSQLIdentChar(p.Data[p.Pos]) {
+		p.Pos++
+	}
+	return p.Data[start:p.Pos]
+}
+
+/* String parses single-quoted literal, where quote is escaped by doubling it. */
+func (p *SQLParser) String() (string, error) {
+	if err := p.Expect('\''); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for {
+		end := strings.IndexByte(p.Data[p.Pos:], '\'')
+		if end == -1 {
+			return "", p.Errorf("unterminated string: %w", SQLUnexpectedEOF)
+		}
+		chunk := p.Data[p.Pos : p.Pos+end]
+		p.Line += strings.Count(chunk, "\n")
+		sb.WriteString(chunk)
+		p.Pos += end + 1
+
+		if (p.Pos < len(p.Data)) && (p.Data[p.Pos] == '\'') {
+			sb.WriteByte('\'')
+			p.Pos++
+			continue
+		}
+		break
+	}
+
+	s := sb.String()
+	if !utf8.ValidString(s) {
+		return "", p.Errorf("string %q is not valid UTF-8", s)
+	}
+	return s, nil
+}
+
+func (p *SQLParser) Int() (int, error) {
+	p.SkipSpaceAndComments()
+	start := p.Pos
+	if (p.Pos < len(p.Data)) && (p.Data[p.Pos] == '-') {
+		p.Pos++
+	}
+	for (p.Pos < len(p.Data)) && (p.Data[p.Pos] >= '0') && (p.Data[p.Pos] <= '9') {
+		p.Pos++
+	}
+	n, err := strconv.Atoi(p.Data[start:p.Pos])
+	if err != nil {
+		return 0, p.Errorf("failed to parse integer: %w", err)
+	}
+	return n, nil
+}
+
+func (p *SQLParser) Value() (SQLValue, error) {
+	var value SQLValue
+	var err error
+
+	if p.Peek() == '\'' {
+		value.IsString = true
+		value.String, err = p.String()
+	} else {
+		value.Int, err = p.Int()
+	}
+	return value, err
+}
+
+/* SkipStatement moves past the next ';' that is not inside string literal. */
+func (p *SQLParser) SkipStatement() {
+	for p.Pos < len(p.Data) {
+		switch p.Data[p.Pos] {
+		case ';':
+			p.Pos++
+			return
+		case '\'':
+			p.String()
+		default:
+			if p.Data[p.Pos] == '\n' {
+				p.Line++
+			}
+			p.Pos++
+		}
+	}
+}
+
+/* Insert parses the rest of 'INSERT INTO' statement and returns table name, column names and rows. */
+func (p *SQLParser) Insert() (string, []string, []SQLRow, error) {
+	var columns []string
+	var rows []SQLRow
+
+	table := p.Ident()
+	if len(table) == 0 {
+		return "", nil, nil, p.Errorf("expected table name")
+	}
+
+	if err := p.Expect('('); err != nil {
+		return "", nil, nil, err
+	}
+	for {
+		column := p.Ident()
+		if len(column) == 0 {
+			return "", nil, nil, p.Errorf("expected column name")
+		}
+		columns = append(columns, strings.ToLower(column))
+		if p.Peek() == ',' {
+			p.Pos++
+			continue
+		}
+		if err := p.Expect(')'); err != nil {
+			return "", nil, nil, err
+		}
+		break
+	}
+
+	if keyword := p.Ident(); !strings.EqualFold(keyword, "VALUES") {
+		return "", nil, nil, p.Errorf("expected VALUES, got %q", keyword)
+	}
+
+	for {
+		if err := p.Expect('('); err != nil {
+			return "", nil, nil, err
+		}
+		row := make(SQLRow, 0, len(columns))
+		for {
+			value, err := p.Value()
+			if err != nil {
+				return "", nil, nil, err
+			}
+			row = append(row, value)
+			if p.Peek() == ',' {
+				p.Pos++
+				continue
+			}
+			if err := p.Expect(')'); err != nil {
+				return "", nil, nil, err
+			}
+			break
+		}
+		if len(row) != len(columns) {
+			return "", nil, nil, p.Errorf("expected %d values, got %d", len(columns), len(row))
+		}
+		rows = append(rows, row)
+
+		if p.Peek() == ',' {
+			p.Pos++
+			continue
+		}
+		if err := p.Expect(';'); err != nil {
+			return "", nil, nil, err
+		}
+		break
+	}
+
+	return strings.ToLower(table), columns, rows, nil
+}
+
+/* ParseFortunesSQL returns fortunes from all 'INSERT INTO fortunes' statements in data, ordered by their IDs, which must be 1, 2, ..., N without gaps or duplicates. Other statements are skipped. */
+func ParseFortunesSQL(data string) ([]Fortune, error) {
+	type sqlFortune struct {
+		ID      int
+		Message string
+	}
+	var sqlFortunes []sqlFortune
+
+	p := SQLParser{Data: data}
+	for p.Peek() != 0 {
+		start := p.Pos
+		if keyword := p.Ident(); !strings.EqualFold(keyword, "INSERT") {
+			p.Pos = start
+			p.SkipStatement()
+			continue
+		}
+		if keyword := p.Ident(); !strings.EqualFold(keyword, "INTO") {
+			return nil, p.Errorf("expected INTO, got %q", keyword)
+		}
+
+		table, columns, rows, err := p.Insert()
+		if err != nil {
+			return nil, err
+		}
+		if table != "fortunes" {
+			continue
+		}
+
+		idColumn, messageColumn := -1, -1
+		for i := 0; i < len(columns); i++ {
+			switch columns[i] {
+			case "id":
+				idColumn = i
+			case "message":
+				messageColumn = i
+			}
+		}
+		if (idColumn == -1) || (messageColumn == -1) {
+			return nil, p.Errorf("fortunes insert must specify both 'id' and 'message' columns")
+		}
+
+		for i := 0; i < len(rows); i++ {
+			id, message := rows[i][idColumn], rows[i][messageColumn]
+			if id.IsString || !message.IsString {
+				return nil, p.Errorf("row %d of fortunes insert has wrong value types", i+1)
+			}
+			sqlFortunes = append(sqlFortunes, sqlFortune{ID: id.Int, Message: message.String})
+		}
+	}
+
+	sort.SliceStable(sqlFortunes, func(i, j int) bool {
+		return sqlFortunes[i].ID < sqlFortunes[j].ID
+	})
+	/* CreateFortune assigns IDs 1, 2, 3 and so on to fortunes of empty database, so only files using the same IDs keep them after import. */
+	fortunes := make([]Fortune, len(sqlFortunes))
+	for i := 0; i < len(sqlFortunes); i++ {
+		if (i > 0) && (sqlFortunes[i].ID == sqlFortunes[i-1].ID) {
+			return nil, fmt.Errorf("duplicate fortune ID %d", sqlFortunes[i].ID)
+		}
+		if sqlFortunes[i].ID != i+1 {
+			return nil, fmt.Errorf("fortune IDs must be consecutive starting from 1, got %d instead of %d", sqlFortunes[i].ID, i+1)
+		}
+		fortunes[i].ID = database.ID(sqlFortunes[i].ID)
+		fortunes[i].Message = sqlFortunes[i].Message
+	}
+
+	return fortunes, nil
+}
+
+/* ImportFortunes replaces contents of FortunesDB with fortunes from SQL file at path. */
+func ImportFortunes(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read SQL file: %w", err)
+	}
+
+	fortunes, err := ParseFortunesSQL(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	if err := DropFortunes(); err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(fortunes); i++ {
+		if err := CreateFortune(&fortunes[i]); err != nil {
+			return 0, fmt.Errorf("failed to create fortune %d: %w", i, err)
+		}
+	}
+
+	return len(fortunes), nil
+}
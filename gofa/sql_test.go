@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseFortunesSQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		messages []string
+		err      string
+	}{
+		{
+			name:     "Single",
+			sql:      "INSERT INTO fortunes(id, message) VALUES (1, 'Hello');",
+			messages: []string{"Hello"},
+		},
+		{
+			name:     "EscapedQuotes",
+			sql:      "INSERT INTO fortunes(id, message) VALUES (1, 'aren''t'), (2, ''''), (3, '');",
+			messages: []string{"aren't", "'", ""},
+		},
+		{
+			name:     "SemicolonInString",
+			sql:      "INSERT INTO fortunes(id, message) VALUES (1, 'a; b');",
+			messages: []string{"a; b"},
+		},
+		{
+			name:     "Multiline",
+			sql:      "INSERT INTO fortunes(id, message) VALUES (1, 'first\nsecond');",
+			messages: []string{"first\nsecond"},
+		},
+		{
+			name:     "ReorderedColumnsAndRows",
+			sql:      "insert into Fortunes (Message, ID) values ('two', 2), ('one', 1);",
+			messages: []string{"one", "two"},
+		},
+		{
+			name: "SeveralStatements",
+			sql: "-- comment; with semicolon\n" +
+				"DROP TABLE IF EXISTS fortunes;\n" +
+				"CREATE TABLE fortunes (id INT NOT NULL, message VARCHAR(128) NOT NULL, PRIMARY KEY (id));\n" +
+				"INSERT INTO world(id, randomNumber) VALUES (1, 100);\n" +
+				"INSERT INTO fortunes(id, message) VALUES (2, 'b');\n" +
+				"INSERT INTO fortunes(id, message) VALUES (1, 'a');\n",
+			messages: []string{"a", "b"},
+		},
+		{
+			name:     "Empty",
+			sql:      "-- nothing here\n",
+			messages: []string{},
+		},
+		{
+			name: "InvalidUTF8",
+			sql:  "INSERT INTO fortunes(id, message) VALUES (1, '\xff');",
+			err:  "not valid UTF-8",
+		},
+		{
+			name: "UnterminatedString",
+			sql:  "INSERT INTO fortunes(id, message) VALUES (1, 'abc);",
+			err:  "unterminated string",
+		},
+		{
+			name: "MissingID",
+			sql:  "INSERT INTO fortunes(id, message) VALUES (2, 'b'), (3, 'c');",
+			err:  "consecutive starting from 1",
+		},
+		{
+			name: "Gap",
+			sql:  "INSERT INTO fortunes(id, message) VALUES (1, 'a'), (3, 'c');",
+			err:  "consecutive starting from 1",
+		},
+		{
+			name: "Duplicate",
+			sql:  "INSERT INTO fortunes(id, message) VALUES (1, 'a'), (1, 'b');",
+			err:  "duplicate fortune ID 1",
+		},
+		{
+			name: "MissingColumn",
+			sql:  "INSERT INTO fortunes(message) VALUES ('a');",
+			err:  "both 'id' and 'message' columns",
+		},
+		{
+			name: "WrongTypes",
+			sql:  "INSERT INTO fortunes(id, message) VALUES ('1', 2);",
+			err:  "wrong value types",
+		},
+		{
+			name: "WrongValueCount",
+			sql:  "INSERT INTO fortunes(id, message) VALUES (1);",
+			err:  "expected 2 values, got 1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fortunes, err := ParseFortunesSQL(test.sql)
+			if len(test.err) > 0 {
+				if (err == nil) || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(fortunes) != len(test.messages) {
+				t.Fatalf("Expected %d fortunes, got %d", len(test.messages), len(fortunes))
+			}
+			for i := 0; i < len(fortunes); i++ {
+				if int(fortunes[i].ID) != i+1 {
+					t.Errorf("Expected fortune %d to have ID %d, got %d", i, i+1, fortunes[i].ID)
+				}
+				if fortunes[i].Message != test.messages[i] {
+					t.Errorf("Expected fortune %d to be %q, got %q", i, test.messages[i], fortunes[i].Message)
+				}
+			}
+		})
+	}
+}
+
+/* TestParseFortunesSQLFile checks that fortunes.sql shared with other implementations is imported in full. */
+func TestParseFortunesSQLFile(t *testing.T) {
+	data, err := os.ReadFile("../database/fortunes.sql")
+	if err != nil {
+		t.Fatalf("Failed to read SQL file: %v", err)
+	}
+
+	fortunes, err := ParseFortunesSQL(string(data))
+	if err != nil {
+		t.Fatalf("Failed to parse SQL file: %v", err)
+	}
+	if len(fortunes) != 12 {
+		t.Fatalf("Expected 12 fortunes, got %d", len(fortunes))
+	}
+	if expected := "A computer scientist is someone who fixes things that aren't broken."; fortunes[1].Message != expected {
+		t.Errorf("Expected fortune 2 to be %q, got %q", expected, fortunes[1].Message)
+	}
+	if expected := "フレームワークのベンチマーク"; fortunes[11].Message != expected {
+		t.Errorf("Expected fortune 12 to be %q, got %q", expected, fortunes[11].Message)
+	}
+}
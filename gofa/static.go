@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	stdsyscall "syscall"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/time"
+)
+
+/* StaticFile keeps file open together with its validators, so serving it requires neither open(2) nor stat(2) most of the time. File is closed once cache and all transfers using it have released their references. */
+type StaticFile struct {
+	File *os.File
+	Refs atomic.Int32
+
+	Size    int64
+	ModTime int64
+
+	ContentType  string
+	ETag         string
+	LastModified string
+
+	CheckedAt atomic.Int64
+}
+
+/* Transfer is a piece of static file that has to be sent after response headers. */
+type Transfer struct {
+	File   *StaticFile
+	Status http.Status
+	Offset int64
+	Length int64
+
+	/* ContentRange is set for 206 responses. */
+	ContentRange string
+}
+
+/* StaticRevalidateInterval is how often, in seconds, cached files are checked for changes on disk. */
+const StaticRevalidateInterval = 1
+
+var (
+	StaticFilesLock sync.RWMutex
+	StaticFiles     = make(map[string]*StaticFile)
+)
+
+func OpenStaticFile(name string, now int) (*StaticFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+
+	file := StaticFile{
+		File:    f,
+		Size:    info.Size(),
+		ModTime: info.ModTime().Unix(),
+	}
+	file.Refs.Store(1)
+	file.CheckedAt.Store(int64(now))
+	file.ContentType = mime.TypeByExtension(filepath.Ext(name))
+	if len(file.ContentType) == 0 {
+		file.ContentType = "application/octet-stream"
+	}
+	file.ETag = fmt.Sprintf(`"%x-%x"`, file.ModTime, file.Size)
+	file.LastModified = stdtime.Unix(file.ModTime, 0).UTC().Format(HTTPTimeFormat)
+
+	return &file, nil
+}
+
+func (file *StaticFile) Acquire() {
+	file.Refs.Add(1)
+}
+
+func (file *StaticFile) Release() {
+	if file.Refs.Add(-1) == 0 {
+		file.File.Close()
+	}
+}
+
+/* GetStaticFile returns cached file for name, reopening it if it was changed on disk. Returned file is acquired and must be released by caller. References are taken under cache lock, so file replaced by another worker can't be closed in between. */
+func GetStaticFile(name string, now int) (*StaticFile, error) {
+	StaticFilesLock.RLock()
+	file, ok := StaticFiles[name]
+	if ok && (int64(now)-file.CheckedAt.Load() < StaticRevalidateInterval) {
+		file.Acquire()
+		StaticFilesLock.RUnlock()
+		return file, nil
+	}
+	StaticFilesLock.RUnlock()
+
+	if ok {
+		info, err := os.Stat(name)
+		if (err == nil) && (info.Size() == file.Size) && (info.ModTime().Unix() == file.ModTime) {
+			StaticFilesLock.RLock()
+			current := StaticFiles[name]
+			if current != nil {
+				current.CheckedAt.Store(int64(now))
+				current.Acquire()
+			}
+			StaticFilesLock.RUnlock()
+			if current != nil {
+				return current, nil
+			}
+		}
+	}
+
+	file, err := OpenStaticFile(name, now)
+	StaticFilesLock.Lock()
+	old := StaticFiles[name]
+	if err != nil {
+		delete(StaticFiles, name)
+	} else {
+		StaticFiles[name] = file
+		file.Acquire()
+	}
+	StaticFilesLock.Unlock()
+	if old != nil {
+		old.Release()
+	}
+	return file, err
+}
+
+const HTTPTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+/* ETagMatches reports whether list of entity tags in header contains etag. Weak comparison is used for If-None-Match and strong one, under which weak tags never match, for If-Match (RFC 9110, section 8.8.3.2). */
+func ETagMatches(header string, etag string, weak bool) bool {
+	for len(header) > 0 {
+		var tag string
+
+		comma := strings.IndexByte(header, ',')
+		if comma == -1 {
+			tag, header = header, ""
+		} else {
+			tag, header = header[:comma], header[comma+1:]
+		}
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[len("W/"):]
+		}
+		if (tag == "*") || (tag == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+/* PreconditionFailed evaluates If-Match and, in its absence, If-Unmodified-Since. Both are checked before conditions handled by NotModified (RFC 9110, section 13.2.2). */
+func PreconditionFailed(r *http.Request, file *StaticFile) bool {
+	if ifMatch := r.Headers.Get("If-Match"); len(ifMatch) > 0 {
+		return !ETagMatches(ifMatch, file.ETag, false)
+	}
+	if ifUnmodifiedSince := r.Headers.Get("If-Unmodified-Since"); len(ifUnmodifiedSince) > 0 {
+		t, err := stdtime.Parse(HTTPTimeFormat, ifUnmodifiedSince)
+		return (err == nil) && (file.ModTime > t.Unix())
+	}
+	return false
+}
+
+func NotModified(r *http.Request, file *StaticFile) bool {
+	if ifNoneMatch := r.Headers.Get("If-None-Match"); len(ifNoneMatch) > 0 {
+		return ETagMatches(ifNoneMatch, file.ETag, true)
+	}
+	if ifModifiedSince := r.Headers.Get("If-Modified-Since"); len(ifModifiedSince) > 0 {
+		t, err := stdtime.Parse(HTTPTimeFormat, ifModifiedSince)
+		return (err == nil) && (file.ModTime <= t.Unix())
+	}
+	return false
+}
+
+/* ParseRange parses single byte range. Multiple ranges are not supported, so ok is false for them and the whole file is sent. */
+func ParseRange(header string, size int64) (offset int64, length int64, ok bool, satisfiable bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.IndexByte(spec, ',') != -1 {
+		return 0, 0, false, true
+	}
+
+	start, end, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, true
+	}
+	if len(start) == 0 {
+		suffix, err := strconv.ParseInt(end, 10, 64)
+		if (err != nil) || (suffix <= 0) {
+			return 0, 0, true, false
+		}
+		suffix = min(suffix, size)
+		return size - suffix, suffix, true, size > 0
+	}
+
+	first, err := strconv.ParseInt(start, 10, 64)
+	if (err != nil) || (first < 0) || (first >= size) {
+		return 0, 0, true, false
+	}
+	last := size - 1
+	if len(end) > 0 {
+		last, err = strconv.ParseInt(end, 10, 64)
+		if (err != nil) || (last < first) {
+			return 0, 0, true, false
+		}
+		last = min(last, size-1)
+	}
+	return first, last - first + 1, true, true
+}
+
+/* StaticHandler serves files from Config.StaticDir. Body is not written here; handler leaves worker.Scratch.Transfer for Router, which either copies it into response, hands it over to sendfile(2) or, for HEAD, only takes its length. Transfer keeps reference to file, which is released once it is complete. */
+func StaticHandler(worker *Worker, w *http.Response, r *http.Request) error {
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, Config.StaticPrefix))
+	file, err := GetStaticFile(filepath.Join(Config.StaticDir, filepath.FromSlash(name)), time.Unix())
+	if err != nil {
+		if os.IsNotExist(err) {
+			NotFound(w)
+			return nil
+		}
+		return http.ServerError(err)
+	}
+
+	pending := false
+	defer func() {
+		if !pending {
+			file.Release()
+		}
+	}()
+
+	w.Headers.Set("ETag", file.ETag)
+	w.Headers.Set("Last-Modified", file.LastModified)
+	w.Headers.Set("Accept-Ranges", "bytes")
+	if PreconditionFailed(r, file) {
+		w.StatusCode = http.StatusPreconditionFailed
+		return nil
+	}
+	if NotModified(r, file) {
+		w.StatusCode = http.StatusNotModified
+		return nil
+	}
+	w.Headers.Set("Content-Type", file.ContentType)
+
+	t := Transfer{File: file, Status: http.StatusOK, Length: file.Size}
+	if rangeHeader := r.Headers.Get("Range"); len(rangeHeader) > 0 {
+		if ifRange := r.Headers.Get("If-Range"); (len(ifRange) == 0) || (ifRange == file.ETag) || (ifRange == file.LastModified) {
+			offset, length, ok, satisfiable := ParseRange(rangeHeader, file.Size)
+			if !satisfiable {
+				w.StatusCode = http.StatusRequestedRangeNotSatisfiable
+				w.Headers.Set("Content-Range", "bytes */"+strconv.FormatInt(file.Size, 10))
+				return nil
+			}
+			if ok {
+				t.Status = http.StatusPartialContent
+				t.Offset = offset
+				t.Length = length
+				t.ContentRange = fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, file.Size)
+				w.Headers.Set("Content-Range", t.ContentRange)
+			}
+		}
+	}
+	w.StatusCode = t.Status
+
+	if t.Length > 0 {
+		worker.Scratch.Transfer = t
+		worker.Scratch.PendingTransfer = true
+		pending = true
+	}
+	return nil
+}
+
+/* CopyTransfer writes transfer into response body. It is used when transfer can't go through sendfile(2), for example when more pipelined responses follow it in the same batch. */
+func CopyTransfer(worker *Worker, w *http.Response, t *Transfer) error {
+	buffer := worker.Scratch.Copy
+	if len(buffer) == 0 {
+		buffer = make([]byte, 16*1024)
+		worker.Scratch.Copy = buffer
+	}
+
+	for t.Length > 0 {
+		n, err := t.File.File.ReadAt(buffer[:min(int64(len(buffer)), t.Length)], t.Offset)
+		if n > 0 {
+			w.Write(buffer[:n])
+			t.Offset += int64(n)
+			t.Length -= int64(n)
+		}
+		if (err != nil) && (t.Length > 0) {
+			return err
+		}
+	}
+	return nil
+}
+
+/* PumpTransfer writes as much of conn's transfer as socket accepts and returns number of bytes written. Everything buffered in ctx, including head of transfer's response, is flushed first to keep pipelined responses in order. conn.Transferring is cleared and file released once transfer is complete. */
+func (worker *Worker) PumpTransfer(ctx *http.Context, conn *Connection) (int, error) {
+	var written int
+
+	t := &conn.Transfer
+	for {
+		n, err := http.Write(ctx)
+		if err != nil {
+			return written, err
+		}
+		written += n
+		if n == 0 {
+			break
+		}
+	}
+
+	/* Socket is full, the rest is written on the next write event. */
+	if HasPendingOutput(ctx) {
+		return written, nil
+	}
+
+	for t.Length > 0 {
+		offset := t.Offset
+		n, err := stdsyscall.Sendfile(int(ctx.Connection), int(t.File.File.Fd()), &offset, int(min(t.Length, 1<<30)))
+		if n > 0 {
+			t.Offset += int64(n)
+			t.Length -= int64(n)
+			written += n
+		}
+		if err == stdsyscall.EAGAIN {
+			return written, nil
+		} else if err != nil {
+			return written, err
+		} else if n == 0 {
+			return written, fmt.Errorf("file was truncated during transfer, %d bytes left", t.Length)
+		}
+	}
+
+	conn.Transferring = false
+	t.File.Release()
+	t.File = nil
+	return written, nil
+}
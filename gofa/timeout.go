@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+type ConnectionState int
+
+const (
+	/* ConnectionIdle is waiting for the next request and expires after Config.IdleTimeout since last read. */
+	ConnectionIdle ConnectionState = iota
+
+	/* ConnectionHeader has received part of a request and expires after Config.HeaderTimeout since its first byte, no matter how slowly the rest is trickling in. */
+	ConnectionHeader
+
+	/* ConnectionWriting has responses in flight and expires after Config.WriteTimeout without write progress. */
+	ConnectionWriting
+)
+
+type Connection struct {
+	Ctx    *http.Context
+	Closed bool
+
+	/* Closing is set once response after which connection must be closed is queued, either error or answer to request that asked for it. Nothing after that is processed, and worker closes connection once everything is written. */
+	Closing bool
+
+	State    ConnectionState
+	Deadline int
+	LastRead int
+
+	/* Transfer is static file being sent with sendfile(2). Requests that follow it are not processed until it completes. */
+	Transfer     Transfer
+	Transferring bool
+}
+
+const Never = math.MaxInt
+
+/* TimingWheel buckets connections by the second of their deadline, so every tick touches only connections that may have expired. Connections whose deadlines were pushed forward are moved lazily when their old slot comes up. */
+type TimingWheel struct {
+	Slots [64][]*Connection
+	Spare []*Connection
+	Now   int
+}
+
+func Deadline(now int, timeout int) int {
+	if timeout == 0 {
+		return Never
+	}
+	return now + timeout
+}
+
+/* Done reports whether closing connection has written its last response. */
+func (conn *Connection) Done() bool {
+	return conn.Closing && (!conn.Transferring) && (!HasPendingOutput(conn.Ctx))
+}
+
+func (conn *Connection) OnRead(now int, complete bool) {
+	conn.LastRead = now
+	if complete {
+		conn.State = ConnectionIdle
+		conn.Deadline = Deadline(now, Config.IdleTimeout)
+	} else if conn.State != ConnectionHeader {
+		conn.State = ConnectionHeader
+		conn.Deadline = Deadline(now, Config.HeaderTimeout)
+	}
+}
+
+func (conn *Connection) OnWrite(now int, n int) {
+	if (n > 0) && (conn.State != ConnectionHeader) {
+		conn.State = ConnectionWriting
+		conn.Deadline = Deadline(now, Config.WriteTimeout)
+	}
+}
+
+func (wheel *TimingWheel) Insert(conn *Connection) {
+	deadline := conn.Deadline
+	if deadline == Never {
+		/* Revisit connections without deadline once per wheel turn in case timeout is set later. */
+		deadline = wheel.Now + len(wheel.Slots) - 1
+	}
+	deadline = max(deadline, wheel.Now+1)
+
+	slot := &wheel.Slots[deadline%len(wheel.Slots)]
+	*slot = append(*slot, conn)
+}
+
+/* Advance moves wheel to now and calls expire for every open connection whose deadline has passed. Connections for which expire returns true are kept in the wheel. */
+func (wheel *TimingWheel) Advance(now int, expire func(conn *Connection, now int) bool) {
+	if now-wheel.Now > len(wheel.Slots) {
+		wheel.Now = now - len(wheel.Slots)
+	}
+
+	for wheel.Now < now {
+		wheel.Now++
+
+		slot := &wheel.Slots[wheel.Now%len(wheel.Slots)]
+		conns := *slot
+		*slot = wheel.Spare[:0]
+
+		for i := 0; i < len(conns); i++ {
+			conn := conns[i]
+			if conn.Closed {
+				continue
+			}
+			if (conn.Deadline > now) || expire(conn, now) {
+				wheel.Insert(conn)
+			}
+		}
+
+		clear(conns)
+		wheel.Spare = conns[:0]
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anton2920/gofa/alloc"
+	"github.com/anton2920/gofa/event"
+	"github.com/anton2920/gofa/log"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/net/http/http1"
+	"github.com/anton2920/gofa/time"
+)
+
+type Worker struct {
+	Queue *event.Queue
+
+	/* Listener is worker's own SO_REUSEPORT socket or -1, if connections are accepted by the main goroutine. */
+	Listener int32
+	Pool     *alloc.SyncPool[http.Context]
+
+	/* Contexts are added by the accept loop and removed by the worker itself. Wheel tracks their timeouts and is protected by the same lock. */
+	ContextsLock sync.Mutex
+	Contexts     map[*http.Context]*Connection
+	Wheel        TimingWheel
+
+	Metrics     Metrics
+	Compressors Compressors
+
+	/* Scratch is reused by handlers running on this worker to avoid allocations. */
+	Scratch Scratch
+
+	Quit atomic.Bool
+}
+
+/* WakeIdentifier identifies user event sent to worker queue by Wake. */
+const WakeIdentifier = 1
+
+/* HasPendingOutput reports whether ctx has response bytes that are not yet written to socket. */
+func HasPendingOutput(ctx *http.Context) bool {
+	return ctx.ResponsePos < len(ctx.ResponseBuffer)
+}
+
+type Scratch struct {
+	Fortunes []Fortune
+	Messages []byte
+	Body     []byte
+	Metrics  []byte
+
+	/* Transfer is set by StaticHandler and consumed by Router. */
+	Transfer        Transfer
+	PendingTransfer bool
+	Copy            []byte
+
+	/* Lengths are set by Router for every response in batch: length of body that is not in response, or -1 if it is. */
+	Lengths []int64
+	Head    []byte
+}
+
+func NewWorker(pool *alloc.SyncPool[http.Context]) (*Worker, error) {
+	var err error
+
+	worker := new(Worker)
+	worker.Queue, err = event.NewQueue()
+	if err != nil {
+		return nil, err
+	}
+	worker.Listener = -1
+	worker.Pool = pool
+	worker.Contexts = make(map[*http.Context]*Connection)
+	worker.Wheel.Now = time.Unix()
+	worker.Metrics.Init()
+
+	/* Advances timing wheel once a second. */
+	_ = worker.Queue.AddTimer(1, 1, event.Seconds, nil)
+	if err := worker.Queue.AddUser(WakeIdentifier); err != nil {
+		worker.Queue.Close()
+		return nil, fmt.Errorf("failed to add wake up event: %w", err)
+	}
+
+	return worker, nil
+}
+
+/* Wake interrupts worker waiting for events, so it notices changes made from other goroutines, like shutdown request. */
+func (worker *Worker) Wake() error {
+	return worker.Queue.TriggerUser(WakeIdentifier, nil)
+}
+
+func (worker *Worker) Listen(address string, backlog int) error {
+	l, err := ListenReusePort(address, backlog)
+	if err != nil {
+		return err
+	}
+	if err := worker.Queue.AddSocket(l, event.RequestRead, event.TriggerEdge, nil); err != nil {
+		CloseListener(l)
+		return fmt.Errorf("failed to add listener to client queue: %w", err)
+	}
+	worker.Listener = l
+	return nil
+}
+
+func (worker *Worker) Add(ctx *http.Context) error {
+	conn := &Connection{Ctx: ctx}
+
+	worker.ContextsLock.Lock()
+	conn.OnRead(worker.Wheel.Now, true)
+	worker.Contexts[ctx] = conn
+	worker.Wheel.Insert(conn)
+	worker.ContextsLock.Unlock()
+	worker.Metrics.OpenContexts.Add(1)
+
+	if err := worker.Queue.AddHTTP(ctx, event.RequestRead, event.TriggerEdge); err != nil {
+		worker.Close(ctx)
+		return err
+	}
+	return nil
+}
+
+func (worker *Worker) Connection(ctx *http.Context) *Connection {
+	worker.ContextsLock.Lock()
+	conn := worker.Contexts[ctx]
+	worker.ContextsLock.Unlock()
+	return conn
+}
+
+/* Close closes connection and returns ctx to the pool it was accepted from. */
+func (worker *Worker) Close(ctx *http.Context) {
+	worker.ContextsLock.Lock()
+	worker.CloseLocked(ctx)
+	worker.ContextsLock.Unlock()
+}
+
+func (worker *Worker) CloseLocked(ctx *http.Context) {
+	if conn, ok := worker.Contexts[ctx]; ok {
+		if conn.Transferring {
+			conn.Transferring = false
+			conn.Transfer.File.Release()
+			conn.Transfer.File = nil
+		}
+		conn.Closed = true
+		delete(worker.Contexts, ctx)
+		worker.Metrics.OpenContexts.Add(-1)
+	}
+	http.Close(ctx)
+}
+
+/* Expire handles connection, whose deadline has passed. Connections that are still writing get one more chance if socket accepts data; those that have written everything become idle. */
+func (worker *Worker) Expire(conn *Connection, now int) bool {
+	if (conn.State == ConnectionWriting) && (!conn.Transferring) {
+		n, err := http.Write(conn.Ctx)
+		if err == nil {
+			worker.Metrics.BytesWritten.Add(int64(n))
+			if HasPendingOutput(conn.Ctx) {
+				/* Client that doesn't read its responses only stays while socket keeps accepting them. */
+				if n > 0 {
+					conn.OnWrite(now, n)
+					return true
+				}
+			} else if conn.Closing {
+				worker.CloseLocked(conn.Ctx)
+				return false
+			} else {
+				conn.State = ConnectionIdle
+				conn.Deadline = Deadline(conn.LastRead, Config.IdleTimeout)
+				if conn.Deadline > now {
+					return true
+				}
+			}
+		}
+	}
+
+	worker.Metrics.Timeouts.Add(1)
+	worker.CloseLocked(conn.Ctx)
+	return false
+}
+
+func (worker *Worker) Tick(now int) {
+	worker.ContextsLock.Lock()
+	worker.Wheel.Advance(now, worker.Expire)
+	worker.ContextsLock.Unlock()
+}
+
+/* Flush tries to write pending data for every open connection and reports how many of them still have some left. */
+func (worker *Worker) Flush() int {
+	var pending int
+
+	worker.ContextsLock.Lock()
+	defer worker.ContextsLock.Unlock()
+
+	for ctx, conn := range worker.Contexts {
+		if conn.Transferring {
+			n, err := worker.PumpTransfer(ctx, conn)
+			worker.Metrics.BytesWritten.Add(int64(n))
+			if err != nil {
+				worker.CloseLocked(ctx)
+				continue
+			}
+			if conn.Transferring {
+				pending++
+				continue
+			}
+		}
+
+		n, err := http.Write(ctx)
+		if err != nil {
+			worker.CloseLocked(ctx)
+			continue
+		}
+		worker.Metrics.BytesWritten.Add(int64(n))
+		if conn.Done() {
+			worker.CloseLocked(ctx)
+		} else if HasPendingOutput(ctx) {
+			pending++
+		}
+	}
+	return pending
+}
+
+func (worker *Worker) CloseAll() {
+	worker.ContextsLock.Lock()
+	defer worker.ContextsLock.Unlock()
+
+	for ctx := range worker.Contexts {
+		worker.CloseLocked(ctx)
+	}
+}
+
+func (worker *Worker) Shutdown(events []event.Event) {
+	q := worker.Queue
+
+	/* Socket that is full now may drain later, so flushing goes on until nothing is left or deadline passes. */
+	deadline := time.Unix() + Config.ShutdownTimeout
+	for (worker.Flush() > 0) && (time.Unix() < deadline) {
+		/* Waits for sockets to become writable again or for the next timer tick. */
+		if _, err := q.GetEvents(events); err != nil {
+			log.Errorf("Failed to get events from client queue: %v", err)
+		}
+	}
+	worker.CloseAll()
+}
+
+/* ProcessRequests parses and handles requests buffered in ctx and returns their number. It stops early when response has to be sent with sendfile(2), leaving the rest until transfer completes, and after request that closes connection. Parser only gets complete requests: those without body are handled in batches, while request with body waits until all of it is buffered and is handled on its own, with body decoded by FrameRequest. */
+func (worker *Worker) ProcessRequests(ctx *http.Context, conn *Connection, ws []http.Response, rs []http.Request, dateBuffer []byte) int {
+	var parsed int
+
+	for (!conn.Transferring) && (!conn.Closing) {
+		var count, length int
+		var hasBody, close bool
+		var body []byte
+		var frame Frame
+
+		data := RequestBytes(ctx)
+		for (count < len(rs)) && (!hasBody) && (!close) {
+			err := FrameRequest(data[length:], worker.Scratch.Body[:0], &frame)
+			if err == RequestIncomplete {
+				break
+			} else if err != nil {
+				/* Requests before the broken one are answered first. */
+				if count == 0 {
+					worker.Reject(ctx, conn, err, dateBuffer)
+				}
+				break
+			}
+			if frame.HasBody {
+				if count > 0 {
+					break
+				}
+				hasBody, body = true, frame.Body
+				worker.Scratch.Body = frame.Body[:0]
+			}
+			close = frame.Close
+			length += frame.Length
+			count++
+		}
+		if count == 0 {
+			break
+		}
+
+		n, err := http1.ParseRequestsUnsafe(ctx, rs[:count])
+		if err != nil {
+			worker.Metrics.ParseErrors.Add(1)
+			worker.Reject(ctx, conn, err, dateBuffer)
+			break
+		}
+		if n == 0 {
+			break
+		}
+		if hasBody {
+			/* Body is owned by FrameRequest, which has already decoded it, so it is consumed here and nowhere else. */
+			if consumed := len(data) - len(RequestBytes(ctx)); consumed != frame.HeadLength {
+				log.Errorf("Parser consumed %d bytes of request with %d bytes long head", consumed, frame.HeadLength)
+				worker.Reject(ctx, conn, http.ServerError(errors.New("request body framing mismatch")), dateBuffer)
+				break
+			}
+			ctx.RequestBuffer.Consume(frame.Length - frame.HeadLength)
+			rs[0].Body = body
+		} else {
+			for i := 0; i < n; i++ {
+				rs[i].Body = nil
+			}
+		}
+		Router(worker, ctx, ws[:n], rs[:n])
+
+		if close && (n == count) {
+			ws[n-1].Headers.Set("Connection", "close")
+			conn.Closing = true
+		}
+		worker.FillResponses(ctx, ws[:n], dateBuffer)
+
+		if worker.Scratch.PendingTransfer {
+			worker.Scratch.PendingTransfer = false
+			conn.Transfer = worker.Scratch.Transfer
+			conn.Transferring = true
+		}
+		parsed += n
+	}
+
+	return parsed
+}
+
+/* FillResponses fills ws into ctx with http1.FillResponses. Responses whose bodies are not in them, HEAD ones and the one sent with sendfile(2), get the same head as others, but with Content-Length set to length recorded by Router. */
+func (worker *Worker) FillResponses(ctx *http.Context, ws []http.Response, dateBuffer []byte) {
+	var from int
+
+	for i := 0; i < len(ws); i++ {
+		if worker.Scratch.Lengths[i] < 0 {
+			continue
+		}
+		if from < i {
+			http1.FillResponses(ctx, ws[from:i], dateBuffer)
+		}
+		start := len(ctx.ResponseBuffer)
+		http1.FillResponses(ctx, ws[i:i+1], dateBuffer)
+		worker.SetContentLength(ctx, start, worker.Scratch.Lengths[i])
+		from = i + 1
+	}
+	if from < len(ws) {
+		http1.FillResponses(ctx, ws[from:], dateBuffer)
+	}
+}
+
+/* SetContentLength replaces value of Content-Length in response head filled into ctx at start for empty body with length. Header is added if filler has omitted it. */
+func (worker *Worker) SetContentLength(ctx *http.Context, start int, length int64) {
+	const header = "Content-Length: "
+
+	head := ctx.ResponseBuffer[start:]
+	valueStart, valueEnd := -1, len(head)-len("\r\n")
+	if i := bytes.Index(head, []byte("\r\n"+header)); i != -1 {
+		valueStart = i + len("\r\n"+header)
+		valueEnd = valueStart + bytes.Index(head[valueStart:], []byte("\r\n"))
+	}
+	tail := append(worker.Scratch.Head[:0], head[valueEnd:]...)
+
+	var buf []byte
+	if valueStart != -1 {
+		buf = ctx.ResponseBuffer[:start+valueStart]
+	} else {
+		buf = append(ctx.ResponseBuffer[:start+valueEnd], header...)
+	}
+	buf = strconv.AppendInt(buf, length, 10)
+	if valueStart == -1 {
+		buf = append(buf, "\r\n"...)
+	}
+	ctx.ResponseBuffer = append(buf, tail...)
+	worker.Scratch.Head = tail
+}
+
+/* Reject answers request that can't be handled with error and closes connection once response is written. Nothing sent after such request is processed. */
+func (worker *Worker) Reject(ctx *http.Context, conn *Connection, err error, dateBuffer []byte) {
+	http1.FillError(ctx, err, dateBuffer)
+	conn.Closing = true
+}
+
+/* ReadRequests reads available bytes from ctx and handles requests in them. Requests that don't fit into buffer are answered with error, other read errors are returned and connection must be closed. */
+func (worker *Worker) ReadRequests(ctx *http.Context, conn *Connection, ws []http.Response, rs []http.Request, dateBuffer []byte, available int) (int, error) {
+	var read, parsed int
+
+	for read < available {
+		n, err := http.Read(ctx)
+		if err != nil {
+			/* Error response can't be queued behind running transfer, so such clients are simply dropped. */
+			if (err == http.NoSpaceLeft) && (!conn.Transferring) {
+				if !conn.Closing {
+					worker.Reject(ctx, conn, err, dateBuffer)
+				}
+				break
+			}
+			return parsed, err
+		}
+		read += n
+		worker.Metrics.BytesRead.Add(int64(n))
+
+		parsed += worker.ProcessRequests(ctx, conn, ws, rs, dateBuffer)
+	}
+
+	return parsed, nil
+}
+
+func ServerWorker(worker *Worker, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	q := worker.Queue
+	events := make([]event.Event, Config.EventsSize)
+
+	ws := make([]http.Response, Config.BatchSize)
+	rs := make([]http.Request, Config.BatchSize)
+
+	for !worker.Quit.Load() {
+		n, err := q.GetEvents(events)
+		if err != nil {
+			log.Errorf("Failed to get events from client queue: %v", err)
+			continue
+		}
+		dateBuffer := GetDateHeader()
+		now := time.Unix()
+
+	events:
+		for i := 0; i < n; i++ {
+			e := &events[i]
+			if errno := e.Error(); errno != 0 {
+				log.Errorf("Event for %v returned code %d (%s)", e.Identifier, errno, errno)
+				continue
+			}
+			if e.Type == event.Timer {
+				worker.Tick(now)
+				continue
+			}
+			if e.Type == event.UserTrigger {
+				/* Sent by Wake; loop condition checks whether it is time to quit. */
+				continue
+			}
+			if (e.Type == event.Read) && (e.UserData == nil) {
+				AcceptConnection(worker.Listener, worker.Pool, worker)
+				continue
+			}
+
+			ctx, ok := http.GetContextFromPointer(e.UserData)
+			if !ok {
+				continue
+			}
+			if e.EndOfFile() {
+				worker.Close(ctx)
+				continue
+			}
+			conn := worker.Connection(ctx)
+			if conn == nil {
+				continue
+			}
+
+			switch e.Type {
+			case event.Read:
+				parsed, err := worker.ReadRequests(ctx, conn, ws, rs, dateBuffer, e.Data)
+				if err != nil {
+					log.Errorf("Failed to read data from client: %v", err)
+					worker.Close(ctx)
+					continue
+				}
+				conn.OnRead(now, (parsed > 0) || conn.Transferring)
+				fallthrough
+			case event.Write:
+				for conn.Transferring {
+					n, err := worker.PumpTransfer(ctx, conn)
+					worker.Metrics.BytesWritten.Add(int64(n))
+					if err != nil {
+						log.Errorf("Failed to send file to client: %v", err)
+						worker.Close(ctx)
+						continue events
+					}
+					conn.OnWrite(now, n)
+					if conn.Transferring {
+						continue events
+					}
+					worker.ProcessRequests(ctx, conn, ws, rs, dateBuffer)
+				}
+
+				n, err := http.Write(ctx)
+				if err != nil {
+					log.Errorf("Failed to write data to client: %v", err)
+					worker.Close(ctx)
+					continue
+				}
+				worker.Metrics.BytesWritten.Add(int64(n))
+				if conn.Done() {
+					/* Closed here rather than with http.CloseAfterWrite, so ctx is removed from worker before it goes back to the pool. */
+					worker.Close(ctx)
+					continue
+				}
+				conn.OnWrite(now, n)
+			}
+		}
+	}
+
+	if worker.Listener != -1 {
+		CloseListener(worker.Listener)
+	}
+	worker.Shutdown(events)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+func TestSetContentLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		head     string
+		length   int64
+		expected string
+	}{
+		{"Replace", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nETag: \"1\"\r\n\r\n", 12345, "HTTP/1.1 200 OK\r\nContent-Length: 12345\r\nETag: \"1\"\r\n\r\n"},
+		{"ReplaceLast", "HTTP/1.1 200 OK\r\nDate: now\r\nContent-Length: 0\r\n\r\n", 7, "HTTP/1.1 200 OK\r\nDate: now\r\nContent-Length: 7\r\n\r\n"},
+		{"Add", "HTTP/1.1 200 OK\r\nDate: now\r\n\r\n", 7, "HTTP/1.1 200 OK\r\nDate: now\r\nContent-Length: 7\r\n\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			/* Response filled before the one being fixed must be left intact. */
+			const previous = "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nhi"
+
+			var worker Worker
+			ctx := http.Context{ResponseBuffer: []byte(previous + test.head)}
+			worker.SetContentLength(&ctx, len(previous), test.length)
+			if got := string(ctx.ResponseBuffer); got != previous+test.expected {
+				t.Errorf("Expected %q, got %q", previous+test.expected, got)
+			}
+		})
+	}
+}
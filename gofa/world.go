@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/net/http"
+)
+
+type World struct {
+	ID           database.ID
+	RandomNumber int32
+}
+
+const (
+	WorldCount = 10000
+
+	MinQueries = 1
+	MaxQueries = 500
+)
+
+var WorldDB *database.DB
+
+/* WorldCachePtr points to the first element of an immutable snapshot of WorldCount rows; world with ID id is at index id-1. */
+var WorldCachePtr unsafe.Pointer
+
+/* WorldLocks serialize read-modify-write cycles on the same row coming from different workers. */
+var WorldLocks [256]sync.Mutex
+
+func RandomWorldID() database.ID {
+	return database.ID(rand.IntN(WorldCount) + 1)
+}
+
+func RandomWorldNumber() int32 {
+	return int32(rand.IntN(WorldCount) + 1)
+}
+
+func CreateWorld(world *World) error {
+	key, err := database.IncrementNextID(WorldDB)
+	if err != nil {
+		return fmt.Errorf("failed to increment world ID: %w", err)
+	}
+	world.ID = key + 1
+
+	return database.Write(WorldDB, key, world)
+}
+
+func GetWorld(id database.ID, world *World) error {
+	return database.Read(WorldDB, RecordKey(id), world)
+}
+
+func UpdateWorld(world *World) error {
+	lock := &WorldLocks[int(world.ID)%len(WorldLocks)]
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := database.Read(WorldDB, RecordKey(world.ID), world); err != nil {
+		return err
+	}
+	newNumber := RandomWorldNumber()
+	for newNumber == world.RandomNumber {
+		newNumber = RandomWorldNumber()
+	}
+	world.RandomNumber = newNumber
+
+	return database.Write(WorldDB, RecordKey(world.ID), world)
+}
+
+func GetCachedWorlds() []World {
+	return unsafe.Slice((*World)(atomic.LoadPointer(&WorldCachePtr)), WorldCount)
+}
+
+func LoadWorldCache() error {
+	worlds := make([]World, WorldCount)
+	var pos int64
+	var total int
+
+	for total < len(worlds) {
+		n, err := database.ReadMany(WorldDB, &pos, worlds[total:])
+		if err != nil {
+			return fmt.Errorf("failed to read worlds: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		total += n
+	}
+	if total != len(worlds) {
+		return fmt.Errorf("expected %d worlds, got %d", len(worlds), total)
+	}
+
+	atomic.StorePointer(&WorldCachePtr, unsafe.Pointer(&worlds[0]))
+	return nil
+}
+
+func DropWorlds() error {
+	if err := database.Drop(WorldDB); err != nil {
+		return fmt.Errorf("failed to drop world data: %w", err)
+	}
+	return nil
+}
+
+func CreateWorlds() error {
+	for i := 0; i < WorldCount; i++ {
+		world := World{RandomNumber: RandomWorldNumber()}
+		if err := CreateWorld(&world); err != nil {
+			return fmt.Errorf("failed to create world %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func WriteWorldJSON(w *http.Response, world *World) {
+	w.WriteString(`{"id":`)
+	WriteJSONInt(w, int(world.ID))
+	w.WriteString(`,"randomNumber":`)
+	WriteJSONInt(w, int(world.RandomNumber))
+	w.WriteString(`}`)
+}
+
+func DBHandler(worker *Worker, w *http.Response, r *http.Request) error {
+	var world World
+
+	if err := GetWorld(RandomWorldID(), &world); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Headers.Set("Content-Type", "application/json")
+	WriteWorldJSON(w, &world)
+	return nil
+}
+
+/* GetQueryValue returns the raw value of the first key=value pair in query with the given key. */
+func GetQueryValue(query string, key string) string {
+	for len(query) > 0 {
+		var pair string
+
+		end := strings.IndexByte(query, '&')
+		if end == -1 {
+			pair, query = query, ""
+		} else {
+			pair, query = query[:end], query[end+1:]
+		}
+
+		if (len(pair) > len(key)) && (pair[len(key)] == '=') && (pair[:len(key)] == key) {
+			return pair[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+/* GetQueryCount parses the number of queries as required by TechEmpower: missing or invalid values count as 1, others are clamped to [1; 500]. */
+func GetQueryCount(r *http.Request, key string) int {
+	n, err := strconv.Atoi(GetQueryValue(r.URL.Query, key))
+	if err != nil {
+		return MinQueries
+	}
+	return min(max(n, MinQueries), MaxQueries)
+}
+
+func QueriesHandler(worker *Worker, w *http.Response, r *http.Request) error {
+	var world World
+
+	n := GetQueryCount(r, "queries")
+
+	w.Headers.Set("Content-Type", "application/json")
+	w.WriteString(`[`)
+	for i := 0; i < n; i++ {
+		if err := GetWorld(RandomWorldID(), &world); err != nil {
+			return http.ServerError(err)
+		}
+		if i > 0 {
+			w.WriteString(`,`)
+		}
+		WriteWorldJSON(w, &world)
+	}
+	w.WriteString(`]`)
+	return nil
+}
+
+func UpdatesHandler(worker *Worker, w *http.Response, r *http.Request) error {
+	var world World
+
+	n := GetQueryCount(r, "queries")
+
+	w.Headers.Set("Content-Type", "application/json")
+	w.WriteString(`[`)
+	for i := 0; i < n; i++ {
+		world.ID = RandomWorldID()
+		if err := UpdateWorld(&world); err != nil {
+			return http.ServerError(err)
+		}
+		if i > 0 {
+			w.WriteString(`,`)
+		}
+		WriteWorldJSON(w, &world)
+	}
+	w.WriteString(`]`)
+	return nil
+}
+
+func CachedQueriesHandler(worker *Worker, w *http.Response, r *http.Request) error {
+	worlds := GetCachedWorlds()
+
+	n := GetQueryCount(r, "count")
+
+	w.Headers.Set("Content-Type", "application/json")
+	w.WriteString(`[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			w.WriteString(`,`)
+		}
+		WriteWorldJSON(w, &worlds[RandomWorldID()-1])
+	}
+	w.WriteString(`]`)
+	return nil
+}